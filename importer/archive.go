@@ -0,0 +1,113 @@
+package importer
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Default patterns for finding the Geo-IP file inside a tar archive, for each source format
+var defaultMemberPatterns = map[string]string{
+	formatSQLite:         "*.sqlite",
+	formatIP2LocationBIN: "*.bin",
+	formatIP2LocationCSV: "*.csv",
+	formatGeoLite2:       "", // Needs a directory of files, so can't come from an archive
+	formatRIR:            "delegated-*",
+}
+
+// Returns true if the path looks like a (possibly gzipped) tar archive
+func isTarball(path string) bool {
+	p := strings.ToLower(path)
+	return strings.HasSuffix(p, ".tar") || strings.HasSuffix(p, ".tar.gz") || strings.HasSuffix(p, ".tgz")
+}
+
+// Extracts the first archive member whose file name matches the pattern to a temporary file, returning the
+// path of the temporary file.  The pattern is matched case insensitively, using filepath.Match syntax
+func extractMember(archivePath, pattern string) (tmpPath string, err error) {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if !strings.HasSuffix(strings.ToLower(archivePath), ".tar") {
+		var gz *gzip.Reader
+		gz, err = gzip.NewReader(f)
+		if err != nil {
+			return
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		var hdr *tar.Header
+		hdr, err = tr.Next()
+		if err == io.EOF {
+			err = fmt.Errorf("No file matching '%s' found in '%s'", pattern, archivePath)
+			return
+		}
+		if err != nil {
+			return
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		var match bool
+		match, err = filepath.Match(strings.ToLower(pattern), strings.ToLower(filepath.Base(hdr.Name)))
+		if err != nil {
+			return
+		}
+		if !match {
+			continue
+		}
+
+		// Make sure there's room for the extracted file, rather than failing part way through
+		err = checkFreeDiskSpace(os.TempDir(), uint64(hdr.Size))
+		if err != nil {
+			return
+		}
+
+		// Copy the member out to a temporary file
+		var tmp *os.File
+		tmp, err = os.CreateTemp("", "geoip-*"+filepath.Ext(hdr.Name))
+		if err != nil {
+			return
+		}
+		_, err = io.Copy(tmp, tr)
+		closeErr := tmp.Close()
+		if err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(tmp.Name())
+			return
+		}
+		logger.Debug("Extracted the Geo-IP source", "file", hdr.Name, "archive", archivePath)
+		return tmp.Name(), nil
+	}
+}
+
+// Checks the file system holding the path has room for the needed number of bytes, plus the configured minimum
+// amount of free space
+func checkFreeDiskSpace(path string, needed uint64) error {
+	free, known, err := freeDiskSpace(path)
+	if err != nil {
+		return err
+	}
+	if !known {
+		return nil
+	}
+	minFree := uint64(minFreeDiskMB) * 1024 * 1024
+	if free < needed+minFree {
+		return fmt.Errorf("Not enough free disk space in '%s'.  Need %d MB (plus %d MB minimum free), but only "+
+			"%d MB is available", path, needed/1024/1024, minFreeDiskMB, free/1024/1024)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package importer
+
+import (
+	"flag"
+	"fmt"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/jackc/pgx"
+)
+
+// Returns the canonical query for looking up the country of an IPv4 address
+func lookupIPv4Query() string {
+	return lookupQuery(familyIPv4, familyIPv4.pgTable)
+}
+
+// Returns the lookup query for an address family, run against the given table
+func lookupQuery(fam ipFamily, table string) string {
+	return fmt.Sprintf(`
+		SELECT ctry
+		FROM %s
+		WHERE %s`, table, lookupCondition(fam))
+}
+
+// Runs the lookup query for a number of random IPv4 addresses, and reports the latency percentiles and throughput
+func benchLookup(args []string) (err error) {
+	fs := flag.NewFlagSet("bench-lookup", flag.ContinueOnError)
+	n := fs.Int("n", 10000, "Number of random IP addresses to look up")
+	err = fs.Parse(args)
+	if err != nil {
+		return
+	}
+	if *n < 1 {
+		return fmt.Errorf("the number of lookups needs to be at least 1")
+	}
+
+	// Connect to PG
+	err = connectPG()
+	if err != nil {
+		return
+	}
+	defer pg.Close()
+	_, err = pg.Prepare("bench_lookup", lookupIPv4Query())
+	if err != nil {
+		return
+	}
+
+	// Time each lookup
+	latencies := make([]time.Duration, *n)
+	var misses int
+	start := time.Now()
+	for i := range latencies {
+		var ctry string
+		ip := int64(rand.Uint32())
+		t := time.Now()
+		err = pg.QueryRow("bench_lookup", ip).Scan(&ctry)
+		latencies[i] = time.Since(t)
+		if err == pgx.ErrNoRows {
+			misses++
+		} else if err != nil {
+			return
+		}
+	}
+	total := time.Since(start)
+
+	// Report the results
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	fmt.Printf("Lookups: %d (%d with no matching range)\n", *n, misses)
+	fmt.Printf("p50: %v, p95: %v, p99: %v, max: %v\n", percentile(latencies, 50), percentile(latencies, 95),
+		percentile(latencies, 99), latencies[len(latencies)-1])
+	fmt.Printf("Throughput: %.1f lookups/second\n", float64(*n)/total.Seconds())
+	return nil
+}
+
+// Returns the given percentile from a sorted list of durations
+func percentile(sorted []time.Duration, p int) time.Duration {
+	i := (len(sorted)*p + 99) / 100
+	if i > 0 {
+		i--
+	}
+	return sorted[i]
+}
@@ -0,0 +1,178 @@
+package importer
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"math/rand"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/jackc/pgx"
+)
+
+// Number of rows (in ipfrom order) included in the sample checksum
+const checksumSampleSize = 1000
+
+// Aggregates over each column of an address family's data, for checking the imported data matches the source
+// beyond just the row count.  The sums catch changed values, the distinct counts catch scrambled columns, and
+// the total lengths catch truncated strings
+type columnAggregates struct {
+	SumIPFrom        string // Decimal, as they go well past 64 bits for IPv6
+	SumIPTo          string
+	SumAssigned      int64
+	DistinctRegistry int
+	DistinctCtry     int
+	DistinctCntry    int
+	DistinctCountry  int
+	LenRegistry      int64
+	LenCtry          int64
+	LenCntry         int64
+	LenCountry       int64
+	SampleMD5        string // Of the first rows, each formatted as ipfrom|ipto|registry|assigned|ctry|cntry|country
+}
+
+// Formats a row the same way as the PostgreSQL side of the sample checksum
+func checksumLine(row oneRow) string {
+	return fmt.Sprintf("%s|%s|%s|%d|%s|%s|%s", row.ipFrom, row.ipTo, row.registry, row.assigned, row.ctry,
+		row.cntry, row.country)
+}
+
+// Works out the column aggregates for an address family in the Geo-IP source
+func sourceAggregates(fam ipFamily) (agg columnAggregates, err error) {
+	sumFrom, sumTo := new(big.Int), new(big.Int)
+	distinct := []map[string]bool{{}, {}, {}, {}}
+	var sample []string
+	err = readSource(fam, func(row oneRow) error {
+		if fixCountryCase {
+			normaliseCountryCase(&row)
+		}
+		sumFrom.Add(sumFrom, row.ipFrom.big())
+		sumTo.Add(sumTo, row.ipTo.big())
+		agg.SumAssigned += int64(row.assigned)
+		for i, v := range []string{row.registry, row.ctry, row.cntry, row.country} {
+			distinct[i][v] = true
+		}
+		agg.LenRegistry += int64(utf8.RuneCountInString(row.registry))
+		agg.LenCtry += int64(utf8.RuneCountInString(row.ctry))
+		agg.LenCntry += int64(utf8.RuneCountInString(row.cntry))
+		agg.LenCountry += int64(utf8.RuneCountInString(row.country))
+		if len(sample) < checksumSampleSize {
+			sample = append(sample, checksumLine(row))
+		}
+		return nil
+	})
+	if err != nil {
+		return
+	}
+	agg.SumIPFrom, agg.SumIPTo = sumFrom.String(), sumTo.String()
+	agg.DistinctRegistry, agg.DistinctCtry = len(distinct[0]), len(distinct[1])
+	agg.DistinctCntry, agg.DistinctCountry = len(distinct[2]), len(distinct[3])
+	sum := md5.Sum([]byte(strings.Join(sample, "\n")))
+	agg.SampleMD5 = hex.EncodeToString(sum[:])
+	return
+}
+
+// Works out the column aggregates for an address family's PG table, matching sourceAggregates
+func pgAggregates(tx *pgx.Tx, fam ipFamily, table string) (agg columnAggregates, err error) {
+	dbQuery := fmt.Sprintf(`
+		SELECT coalesce(sum(ipfrom), 0)::text, coalesce(sum(ipto), 0)::text, coalesce(sum(assigned), 0)::bigint,
+			count(DISTINCT registry), count(DISTINCT ctry), count(DISTINCT cntry), count(DISTINCT country),
+			coalesce(sum(char_length(registry)), 0), coalesce(sum(char_length(ctry)), 0),
+			coalesce(sum(char_length(cntry)), 0), coalesce(sum(char_length(country)), 0),
+			(
+				SELECT md5(coalesce(string_agg(concat_ws('|', ipfrom, ipto, registry, assigned, ctry, cntry, country),
+					E'\n' ORDER BY ipfrom), ''))
+				FROM (
+					SELECT *
+					FROM %[1]s
+					WHERE %[3]s
+					ORDER BY ipfrom
+					LIMIT %[2]d
+				) AS first_rows
+			)
+		FROM %[1]s
+		WHERE %[3]s`, table, checksumSampleSize, familyCondition(fam))
+	err = tx.QueryRow(dbQuery).Scan(&agg.SumIPFrom, &agg.SumIPTo, &agg.SumAssigned, &agg.DistinctRegistry,
+		&agg.DistinctCtry, &agg.DistinctCntry, &agg.DistinctCountry, &agg.LenRegistry, &agg.LenCtry, &agg.LenCntry,
+		&agg.LenCountry, &agg.SampleMD5)
+	return
+}
+
+// Compares the column aggregates of an address family's source data and its PG table, failing if any differ
+func checksumVerify(tx *pgx.Tx, fam ipFamily, table string) (err error) {
+	src, err := sourceAggregates(fam)
+	if err != nil {
+		return
+	}
+	dst, err := pgAggregates(tx, fam, table)
+	if err != nil {
+		return
+	}
+	if src != dst {
+		return fmt.Errorf("The %s column checksums don't match the source.\n  Source:     %+v\n  PostgreSQL: %+v",
+			fam.name, src, dst)
+	}
+	logger.Info("Column checksums match the source", "family", fam.name)
+	return
+}
+
+// Picks n random rows from an address family's source data, and checks each of them has been imported into the
+// PG table with every field intact
+func sampleRoundTrip(tx *pgx.Tx, fam ipFamily, table string, n int) (err error) {
+	// Pick the rows with reservoir sampling, so the source doesn't need to be held in memory
+	var sample []oneRow
+	var seen int
+	err = readSource(fam, func(row oneRow) error {
+		if fixCountryCase {
+			normaliseCountryCase(&row)
+		}
+		seen++
+		if len(sample) < n {
+			sample = append(sample, row)
+		} else if i := rand.Intn(seen); i < n {
+			sample[i] = row
+		}
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	// Fetch each one back from PG, and compare the fields
+	dbQuery := fmt.Sprintf(`
+		SELECT ipfrom::text, ipto::text, registry, assigned, ctry, cntry, country
+		FROM %s
+		WHERE %s AND ipfrom = $1`, table, familyCondition(fam))
+	var mismatches []string
+	for _, want := range sample {
+		var got oneRow
+		var ipFrom, ipTo string
+		err = tx.QueryRow(dbQuery, fam.keyArg(want.ipFrom)).Scan(&ipFrom, &ipTo, &got.registry, &got.assigned,
+			&got.ctry, &got.cntry, &got.country)
+		if err == pgx.ErrNoRows {
+			mismatches = append(mismatches, fmt.Sprintf("  %s: missing from PostgreSQL", want.ipFrom))
+			continue
+		}
+		if err != nil {
+			return
+		}
+		if got.ipFrom, err = parseIPNum(ipFrom); err != nil {
+			return
+		}
+		if got.ipTo, err = parseIPNum(ipTo); err != nil {
+			return
+		}
+		if got != want {
+			mismatches = append(mismatches, fmt.Sprintf("  Source:     %s\n  PostgreSQL: %s", checksumLine(want),
+				checksumLine(got)))
+		}
+	}
+	if len(mismatches) > 0 {
+		return fmt.Errorf("%d of %d sampled %s rows don't match the source:\n%s", len(mismatches), len(sample),
+			fam.name, strings.Join(mismatches, "\n"))
+	}
+	logger.Info("Sampled rows match the source", "family", fam.name, "rows", len(sample))
+	return
+}
@@ -0,0 +1,190 @@
+package importer
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// A staging or backup table left behind by an earlier import
+type leftoverTable struct {
+	name    string
+	bytes   int64
+	created time.Time // Zero when it isn't known
+}
+
+// Finds the import time at the end of the default table comment
+var commentTimeRegex = regexp.MustCompile(` at (\S+)$`)
+
+// Lists the staging (_new) and backup (_old) tables of the country lookup tables, with their sizes and ages, and
+// drops those older than -older-than or matching -pattern.  Without either nothing is dropped, and with -dry-run
+// it's only shown what would be.  PostgreSQL imports (and the Redshift and SQLite ones) run in a single
+// transaction, so an interrupted import doesn't leave these behind there, but an interrupted MySQL import can, as
+// its DDL commits straight away
+func cleanup(args []string) (err error) {
+	fs := flag.NewFlagSet("cleanup", flag.ContinueOnError)
+	olderThan := fs.Duration("older-than", 0, "Drop the leftover tables which are older than this, eg 168h")
+	pattern := fs.String("pattern", "", "Drop the leftover tables with names matching this glob pattern, eg '*_v6_new'")
+	dryRun := fs.Bool("dry-run", false, "Show which tables would be dropped, without dropping them")
+	err = fs.Parse(args)
+	if err != nil {
+		return
+	}
+	if _, err = path.Match(*pattern, ""); err != nil {
+		return fmt.Errorf("Invalid -pattern '%s': %v", *pattern, err)
+	}
+
+	// Find the leftover tables
+	var tables []leftoverTable
+	var drop func(table string) error
+	switch targetDriver() {
+	case driverPostgreSQL:
+		err = connectPG()
+		if err != nil {
+			return
+		}
+		defer pg.Close()
+		tables, err = pgLeftoverTables(leftoverTableNames())
+		drop = func(table string) error {
+			_, err := pg.Exec(fmt.Sprintf(`DROP TABLE %s`, table))
+			return err
+		}
+	case driverMySQL:
+		var db *sql.DB
+		db, err = connectMySQL()
+		if err != nil {
+			return
+		}
+		defer db.Close()
+		tables, err = mysqlLeftoverTables(db, leftoverTableNames())
+		drop = func(table string) error {
+			_, err := db.Exec(fmt.Sprintf(`DROP TABLE %s`, table))
+			return err
+		}
+	default:
+		return fmt.Errorf("cleanup only covers the postgresql and mysql targets, as the others don't leave "+
+			"tables behind.  The target is %s", targetDriver())
+	}
+	if err != nil {
+		return
+	}
+	if len(tables) == 0 {
+		fmt.Println("No leftover staging or backup tables found")
+		return
+	}
+
+	// Show them, dropping the ones asked for
+	now := time.Now()
+	for _, t := range tables {
+		action := "kept"
+		if shouldDropLeftover(t, *olderThan, *pattern, now) {
+			action = "would drop"
+			if !*dryRun {
+				logger.Info("Dropping the leftover table", "table", t.name)
+				err = drop(t.name)
+				if err != nil {
+					return
+				}
+				action = "dropped"
+			}
+		}
+		age := "unknown"
+		if !t.created.IsZero() {
+			age = now.Sub(t.created).Round(time.Minute).String()
+		}
+		fmt.Printf("%-40s %10.1f MB  age %-12s %s\n", t.name, float64(t.bytes)/(1<<20), age, action)
+	}
+	return
+}
+
+// Returns the names the staging and backup tables of the country lookup tables would have
+func leftoverTableNames() (names []string) {
+	for _, fam := range families {
+		for _, name := range []string{stagingTable(fam), fam.pgTable + "_old"} {
+			if !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+		}
+	}
+	return
+}
+
+// Returns true if a leftover table is older than olderThan, or its name matches the pattern.  Tables of unknown
+// age are only dropped by the pattern
+func shouldDropLeftover(t leftoverTable, olderThan time.Duration, pattern string, now time.Time) bool {
+	if olderThan > 0 && !t.created.IsZero() && now.Sub(t.created) > olderThan {
+		return true
+	}
+	if pattern == "" {
+		return false
+	}
+	matched, _ := path.Match(pattern, t.name)
+	return matched
+}
+
+// Finds which of the named tables exist in PostgreSQL.  PostgreSQL doesn't record when a table was created, so its
+// age is taken from the time at the end of the default table comment
+func pgLeftoverTables(names []string) (tables []leftoverTable, err error) {
+	rows, err := pg.Query(`
+		SELECT c.relname, pg_total_relation_size(c.oid), coalesce(obj_description(c.oid, 'pg_class'), '')
+		FROM pg_class c
+		WHERE c.relnamespace = current_schema()::regnamespace
+			AND c.relkind = 'r'
+			AND c.relname = ANY($1)
+		ORDER BY c.relname`, names)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t leftoverTable
+		var comment string
+		err = rows.Scan(&t.name, &t.bytes, &comment)
+		if err != nil {
+			return
+		}
+		if m := commentTimeRegex.FindStringSubmatch(comment); m != nil {
+			t.created, _ = time.Parse(time.RFC3339, m[1])
+		}
+		tables = append(tables, t)
+	}
+	err = rows.Err()
+	return
+}
+
+// Finds which of the named tables exist in MySQL, with their creation times
+func mysqlLeftoverTables(db *sql.DB, names []string) (tables []leftoverTable, err error) {
+	args := make([]interface{}, len(names))
+	for i, name := range names {
+		args[i] = name
+	}
+	rows, err := db.Query(fmt.Sprintf(`
+		SELECT table_name, coalesce(data_length + index_length, 0), unix_timestamp(create_time)
+		FROM information_schema.tables
+		WHERE table_schema = database()
+			AND table_name IN (%s)
+		ORDER BY table_name`, strings.TrimSuffix(strings.Repeat("?, ", len(names)), ", ")), args...)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t leftoverTable
+		var created sql.NullInt64
+		err = rows.Scan(&t.name, &t.bytes, &created)
+		if err != nil {
+			return
+		}
+		if created.Valid {
+			t.created = time.Unix(created.Int64, 0)
+		}
+		tables = append(tables, t)
+	}
+	err = rows.Err()
+	return
+}
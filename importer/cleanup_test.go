@@ -0,0 +1,65 @@
+package importer
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestShouldDropLeftover(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	old := leftoverTable{name: "country_code_lookups_new", created: now.Add(-48 * time.Hour)}
+	recent := leftoverTable{name: "country_code_lookups_v6_new", created: now.Add(-time.Hour)}
+	unknown := leftoverTable{name: "country_code_lookups_old"}
+	tests := []struct {
+		name      string
+		t         leftoverTable
+		olderThan time.Duration
+		pattern   string
+		want      bool
+	}{
+		{"nothing asked for", old, 0, "", false},
+		{"older", old, 24 * time.Hour, "", true},
+		{"newer", recent, 24 * time.Hour, "", false},
+		{"unknown age", unknown, time.Hour, "", false},
+		{"matching pattern", recent, 24 * time.Hour, "*_v6_new", true},
+		{"other pattern", recent, 0, "*_old", false},
+		{"unknown age by pattern", unknown, 0, "*_old", true},
+	}
+	for _, tt := range tests {
+		if got := shouldDropLeftover(tt.t, tt.olderThan, tt.pattern, now); got != tt.want {
+			t.Errorf("%s: shouldDropLeftover() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLeftoverTableNames(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.Options.TableName = "geo"
+	for _, combined := range []bool{false, true} {
+		cfg.Options.CombinedTable = combined
+		done, err := New(cfg).start(context.Background(), false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := strings.Join(leftoverTableNames(), " ")
+		done()
+		want := "geo_new geo_old geo_v6_new geo_v6_old"
+		if combined {
+			want = "geo_new geo_old"
+		}
+		if got != want {
+			t.Errorf("leftoverTableNames() with a combined table %v = %q, want %q", combined, got, want)
+		}
+	}
+}
+
+func TestCleanupRejectsOtherTargets(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.Target = TargetInfo{Driver: driverSQLite, Path: "out.sqlite"}
+	err := New(cfg).RunCommand(context.Background(), []string{"cleanup", "-dry-run"})
+	if err == nil || !strings.Contains(err.Error(), "only covers the postgresql and mysql targets") {
+		t.Errorf("cleanup with a sqlite target = %v", err)
+	}
+}
@@ -0,0 +1,72 @@
+package importer
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Checks the options used with a combined table.  Only a plain replace of the country lookup table in PostgreSQL
+// is supported, as the other modes assume each address family has a table of its own
+func validateCombinedTable() error {
+	if !combinedTable {
+		return nil
+	}
+	switch {
+	case targetDriver() != driverPostgreSQL:
+		return fmt.Errorf("-combined-table is only supported for the postgresql target")
+	case upsert:
+		return fmt.Errorf("-combined-table can't be used with -upsert")
+	case conf.FDW.Server != "":
+		return fmt.Errorf("-combined-table can't be used with a foreign table")
+	case conf.Pg.SchemaMode != "":
+		return fmt.Errorf("-combined-table can't be used with schema_mode")
+	case fastVerify:
+		return fmt.Errorf("-combined-table can't be used with -fast-verify, as the planner's row estimate " +
+			"covers both address families")
+	}
+	return nil
+}
+
+// Returns the condition selecting an address family's rows from its country lookup table, for adding to a WHERE
+// clause.  That's only needed for a combined table, so otherwise it's always true
+func familyCondition(fam ipFamily) string {
+	if !combinedTable {
+		return "true"
+	}
+	return fmt.Sprintf("family = %d", fam.version)
+}
+
+// Returns the address families out of the given ones which have a country lookup table of their own.  With a
+// combined table that's only the first, as the others share its table
+func tableFamilies(fams []ipFamily) []ipFamily {
+	if combinedTable && len(fams) > 1 {
+		return fams[:1]
+	}
+	return fams
+}
+
+// Returns the CREATE TABLE statement for the combined country lookup table.  The keys are numeric, to fit the IPv6
+// addresses, and the family column leads the primary key so lookups for one address family can use it
+func createCombinedTableSQL(table string) string {
+	return fmt.Sprintf(`
+		CREATE TABLE %[1]s (
+			family smallint not null,
+			ipfrom %[2]s,
+			ipto %[2]s,
+			registry text,
+			assigned bigint,
+			ctry text,
+			cntry text,
+			country text,
+			constraint %[3]s primary key (family, ipfrom)
+		)`, table, familyIPv6.keyType, primaryKeyName(table))
+}
+
+// Returns the columns of a configured index.  For a combined table they're led by the family column, as lookups
+// only look at one address family's rows
+func indexColumns(cols []string) string {
+	if combinedTable {
+		cols = append([]string{"family"}, cols...)
+	}
+	return strings.Join(cols, ", ")
+}
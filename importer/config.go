@@ -0,0 +1,280 @@
+package importer
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	sqlite "github.com/gwenn/gosqlite"
+	"github.com/jackc/pgx"
+)
+
+// Settings for an import.  Everything other than Options can be read from a TOML config file with LoadConfig
+type Config struct {
+	FDW      FDWInfo
+	Geo      GeoInfo
+	Log      LogInfo
+	Metrics  MetricsInfo
+	Pg       PGInfo
+	Profiles map[string]toml.Primitive // Named sets of settings, overriding the top level ones when selected
+	Schedule ScheduleInfo              // When to refresh the data in daemon mode
+	Tables   []TableInfo               // Extra output tables, holding a subset of the columns
+	Target   TargetInfo                // Where to write the data, when it's not PostgreSQL
+
+	Options Options `toml:"-"` // How to run the import.  These are the command line options of the binary
+}
+type FDWInfo struct {
+	Schema  string // Schema of the table on the remote server.  Defaults to "public"
+	Server  string // Name of the postgres_fdw foreign server.  When set, a foreign table is used instead of a local one
+	Table   string // Name of the IPv4 table on the remote server.  Defaults to "country_code_lookups"
+	TableV6 string `toml:"table_v6"` // Name of the IPv6 table on the remote server.  Defaults to "country_code_lookups_v6"
+}
+type GeoInfo struct {
+	ChecksumURL string `toml:"checksum_url"` // Where to download the SHA-256 checksum of the file at URL from.  Optional
+	Format      string // Format of the Geo-IP file.  Either "sqlite" (the default), "ip2location-bin", "ip2location-csv", "geolite2", or "rir"
+	LicenseKey  string `toml:"license_key"` // Substituted for {license_key} in the URL
+	Member      string // When Path is a tar archive, the file name pattern of the Geo-IP file inside it
+	OpenRetries int    `toml:"open_retries"` // Number of times to retry opening the Geo-IP.sqlite file
+	Path        string // Path to the Geo-IP.sqlite file, or a tar archive containing it.  For GeoLite2, the directory of CSV files
+	URL         string // Where to download the Geo-IP file from, to Path.  Optional
+}
+type LogInfo struct {
+	Format string // Either "text" (the default) or "json"
+	Level  string // Either "debug", "info", "warn", or "error".  Defaults to info, or with the binary to follow -debug
+}
+type MetricsInfo struct {
+	Job         string // Pushgateway job name.  Defaults to "ip_country_code_lookup_importer"
+	Prefix      string // Prefix of the statsd metric names.  Defaults to "ip_country_code_lookup_importer"
+	Pushgateway string // URL of a Prometheus Pushgateway to push the run metrics to, eg "http://localhost:9091"
+	Statsd      string // Address of a statsd server to send the run metrics to, eg "localhost:8125"
+}
+type ScheduleInfo struct {
+	Cron     string // Cron expression for when to refresh, eg "0 3 * * *".  Takes precedence over Interval
+	Interval string // How often to refresh, eg "24h"
+	Listen   string // Address to serve the health endpoint on, eg ":8080".  Optional
+}
+type TargetInfo struct {
+	Database    string
+	Driver      string // Either "postgresql" (the default, using the [pg] settings), "mysql", "redshift", or "sqlite"
+	IAMRole     string `toml:"iam_role"` // IAM role Redshift reads the S3 bucket with.  Defaults to using the S3 keys
+	Password    string
+	Path        string // SQLite file to write to
+	Port        int
+	S3AccessKey string `toml:"s3_access_key"` // Defaults to the AWS_ACCESS_KEY_ID environment variable
+	S3Bucket    string `toml:"s3_bucket"`     // Bucket the Redshift data is uploaded to, for COPY to load
+	S3Endpoint  string `toml:"s3_endpoint"`   // URL of an S3 compatible server.  Defaults to AWS
+	S3Prefix    string `toml:"s3_prefix"`     // Folder in the bucket to upload to, eg "imports/geoip"
+	S3Region    string `toml:"s3_region"`     // AWS region of the bucket, eg "us-east-1"
+	S3SecretKey string `toml:"s3_secret_key"` // Defaults to the AWS_SECRET_ACCESS_KEY environment variable
+	Server      string
+	Username    string
+}
+type TableInfo struct {
+	Name    string
+	Columns []string
+	Family  string // Address family of the data it holds.  Either "ipv4" (the default) or "ipv6"
+}
+type PGInfo struct {
+	ClientEncoding string `toml:"client_encoding"` // Defaults to UTF8
+	Database       string
+	LockTimeout    string `toml:"lock_timeout"` // How long to wait for table locks during the DDL, eg "5s"
+	NumConnections int    `toml:"num_connections"`
+	Port           int
+	Password       string
+	Server         string
+	SchemaMode     string `toml:"schema_mode"` // Adds a range column to the lookup tables.  Either "range" or "ip4r"
+	SSL            bool
+	SSLCA          string `toml:"ssl_ca"`       // CA certificate file to verify the server with.  Defaults to the system CAs
+	SSLCert        string `toml:"ssl_cert"`     // Client certificate file, for certificate authentication
+	SSLInsecure    bool   `toml:"ssl_insecure"` // Skip verifying the server certificate.  Same as ssl_mode "require"
+	SSLKey         string `toml:"ssl_key"`      // Private key file for the client certificate
+	SSLMode        string `toml:"ssl_mode"`     // Either "disable", "require", "verify-ca", or "verify-full"
+	Timezone       string // Session time zone.  Defaults to UTC
+	Username       string
+}
+
+// How long to wait for table locks during the DDL, when not set in the config file
+const defaultLockTimeout = 5 * time.Second
+
+// Number of addresses in a /8, the largest block the RIRs have ever allocated in one go
+const defaultMaxRangeSize = 1 << 24
+
+// Supported Geo-IP source file formats
+const (
+	formatSQLite         = "sqlite"
+	formatIP2LocationBIN = "ip2location-bin"
+	formatIP2LocationCSV = "ip2location-csv"
+	formatGeoLite2       = "geolite2"
+	formatRIR            = "rir"
+)
+
+// An IP address family, and where its data lives in the Geo-IP source and in PostgreSQL
+type ipFamily struct {
+	name        string // For messages, eg "IPv4"
+	sourceTable string // Table in the Geo-IP.sqlite file
+	pgTable     string // Table in PostgreSQL
+	keyType     string // PostgreSQL type of the ipfrom and ipto columns
+	version     int    // Stored in the family column of a combined table.  Either 4 or 6
+}
+
+// Name of the IPv4 country lookup table, when it's not given in the options
+const defaultTableName = "country_code_lookups"
+
+// The address families which are imported.  IPv6 addresses don't fit in a bigint, so they're stored as numeric
+var (
+	familyIPv4 = ipFamily{name: "IPv4", sourceTable: "ipv4", pgTable: defaultTableName, keyType: "bigint",
+		version: 4}
+	familyIPv6 = ipFamily{name: "IPv6", sourceTable: "ipv6", pgTable: defaultTableName + "_v6",
+		keyType: "numeric(39,0)", version: 6}
+	families = []ipFamily{familyIPv4, familyIPv6}
+)
+
+// Renames the country lookup tables.  The IPv6 table is named after the IPv4 one, unless they share a combined
+// table
+func setTableName(name string) {
+	familyIPv4.pgTable = name
+	familyIPv6.pgTable = name + "_v6"
+	if combinedTable {
+		familyIPv6.pgTable = name
+	}
+	families = []ipFamily{familyIPv4, familyIPv6}
+}
+
+type oneRow struct {
+	ipFrom   ipNum
+	ipTo     ipNum
+	registry string
+	assigned int
+	ctry     string
+	cntry    string
+	country  string
+}
+
+var (
+	// Settings of the running import
+	conf Config
+
+	// Context of the running import.  Cancelling it stops the import at the next row or long running statement
+	runCtx = context.Background()
+
+	// How many rows to import between progress messages.  Zero turns them off
+	progressEvery int
+
+	// PostgreSQL Connection pool
+	pg *pgx.ConnPool
+
+	// TLS settings for the PostgreSQL connections.  Nil when SSL is disabled
+	pgTLS *tls.Config
+
+	// SQLite pieces
+	sdb *sqlite.Conn
+
+	// The Geo-IP source, when it's read directly rather than through SQLite
+	ranges rangeSource
+
+	// Roll back the import if any warnings were raised?
+	rollbackOnWarning bool
+
+	// Comment to place on the country lookup table
+	tableComment string
+
+	// Upper case the country codes, and optionally change the case of the country names
+	fixCountryCase  bool
+	countryNameCase string
+
+	// Indexes to create on the imported data, each a list of columns
+	indexSpecs [][]string
+
+	// Amount of disk space to leave free when extracting files, in MB
+	minFreeDiskMB int
+
+	// Verify the row count using the planner's estimate, rather than counting the rows?
+	fastVerify bool
+
+	// Check the lookup query uses an index after importing?
+	assertIndex bool
+
+	// Merge the new data into the existing tables, instead of dropping and recreating them?
+	upsert bool
+
+	// Keep both address families in one table, told apart by its family column?
+	combinedTable bool
+
+	// Download and import the Geo-IP source even if it hasn't changed since the last import?
+	forceImport bool
+
+	// Number of random addresses to check resolve the same way in the source and PG after importing
+	shuffleCheckCount int
+
+	// Compare per column checksums of the source and PG after importing?
+	checksumCheck bool
+
+	// Number of random rows to fetch back from PG and compare with the source after importing
+	sampleCheckCount int
+
+	// How often to log a heartbeat during the import.  Zero turns it off
+	heartbeatInterval time.Duration
+
+	// URL to POST the import summary to, and whether to send it as a Slack message
+	webhookURL   string
+	webhookSlack bool
+
+	// Largest number of addresses a single range can plausibly cover.  When not set, the per registry limits are used
+	maxRangeSize int
+
+	// Per registry limits on the number of addresses a single range can cover.  Registries not listed here use
+	// defaultMaxRangeSize
+	registryMaxRangeSizes = map[string]int{
+		"iana": 1 << 28, // A /4, as IANA holds the large multicast and reserved blocks
+	}
+
+	// Warnings raised during the import.  Only the first maxStoredWarnings are kept, but they're all counted
+	warnings     []string
+	warningCount int
+
+	// Descriptions of each column in the country lookup table, used for the column comments
+	columnComments = []struct{ name, comment string }{
+		{"ipfrom", "First IP address in the range, as an integer"},
+		{"ipto", "Last IP address in the range, as an integer"},
+		{"registry", "Regional Internet Registry the range is assigned by"},
+		{"assigned", "When the range was assigned, as a Unix timestamp"},
+		{"ctry", "ISO 3166 2 letter country code"},
+		{"cntry", "ISO 3166 3 letter country code"},
+		{"country", "Country name"},
+	}
+
+	// Patterns the 2 and 3 letter country codes need to match
+	ctryRegex  = regexp.MustCompile(`^[A-Z]{2}$`)
+	cntryRegex = regexp.MustCompile(`^[A-Z]{3}$`)
+)
+
+// Reads the settings from a TOML config file, with the options set to DefaultOptions.  When a profile is given,
+// its settings from the file's [profiles] section are applied on top of the top level ones.  An empty path gives
+// the default settings, which is only useful without a profile
+func LoadConfig(path, profile string) (cfg Config, err error) {
+	var md toml.MetaData
+	if path != "" {
+		md, err = toml.DecodeFile(path, &cfg)
+		if err != nil {
+			return
+		}
+	}
+	if profile != "" {
+		p, ok := cfg.Profiles[profile]
+		if !ok && path == "" {
+			return cfg, fmt.Errorf("Profile '%s' was given, but there's no config file", profile)
+		}
+		if !ok {
+			return cfg, fmt.Errorf("Profile '%s' isn't defined in '%s'", profile, path)
+		}
+		err = md.PrimitiveDecode(p, &cfg)
+		if err != nil {
+			return
+		}
+	}
+	cfg.Options = DefaultOptions()
+	return
+}
@@ -0,0 +1,122 @@
+package importer
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A parsed 5 field cron expression (minute, hour, day of month, month, day of week).  Each field is the set of
+// values it matches
+type cronSchedule struct {
+	minute, hour, dom, month, dow map[int]bool
+	domAny, dowAny                bool // Whether the day fields were "*", for cron's rule on combining them
+}
+
+// Parses a standard 5 field cron expression.  Each field can be "*", a number, a range ("1-5"), a list of those
+// ("1,15"), and have a step ("*/15", "0-30/10").  A step on a single number runs from there to the end of the
+// range, so "5/15" is the same as "5-59/15".  Day of week 0 and 7 are both Sunday.  Expressions which can never
+// match, such as "0 0 30 2 *", are rejected
+func parseCron(expr string) (c *cronSchedule, err error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression '%s' needs 5 fields, but has %d", expr, len(fields))
+	}
+	c = &cronSchedule{domAny: fields[2] == "*", dowAny: fields[4] == "*"}
+	limits := []struct{ min, max int }{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+	sets := []*map[int]bool{&c.minute, &c.hour, &c.dom, &c.month, &c.dow}
+	for i, f := range fields {
+		*sets[i], err = parseCronField(f, limits[i].min, limits[i].max)
+		if err != nil {
+			return nil, fmt.Errorf("cron expression '%s': %v", expr, err)
+		}
+	}
+	if c.dow[7] {
+		c.dow[0] = true
+	}
+	if c.next(time.Now()).IsZero() {
+		return nil, fmt.Errorf("cron expression '%s' never matches", expr)
+	}
+	return
+}
+
+// Parses one field of a cron expression into the set of values it matches
+func parseCronField(field string, min, max int) (set map[int]bool, err error) {
+	set = make(map[int]bool)
+	for _, part := range strings.Split(field, ",") {
+		step, hasStep := 1, false
+		if i := strings.Index(part, "/"); i >= 0 {
+			hasStep = true
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step < 1 {
+				return nil, fmt.Errorf("invalid step in '%s'", part)
+			}
+			part = part[:i]
+		}
+		lo, hi := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			if lo, err = strconv.Atoi(bounds[0]); err != nil {
+				return nil, fmt.Errorf("invalid value '%s'", part)
+			}
+			hi = lo
+			if hasStep {
+				hi = max
+			}
+			if len(bounds) == 2 {
+				if hi, err = strconv.Atoi(bounds[1]); err != nil {
+					return nil, fmt.Errorf("invalid value '%s'", part)
+				}
+			}
+			if lo < min || hi > max || lo > hi {
+				return nil, fmt.Errorf("'%s' is outside the range %d-%d", part, min, max)
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			set[v] = true
+		}
+	}
+	return
+}
+
+// Returns the first time after t which matches the schedule, or the zero time if nothing ever does
+func (c *cronSchedule) next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+
+	// A matching time is always found within a few years, even for things like 29th February
+	for limit := t.AddDate(5, 0, 0); t.Before(limit); {
+		if !c.month[int(t.Month())] {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !c.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !c.hour[t.Hour()] {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if !c.minute[t.Minute()] {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// As in cron, when both the day of month and day of week are restricted, a day matching either of them matches
+func (c *cronSchedule) dayMatches(t time.Time) bool {
+	dom, dow := c.dom[t.Day()], c.dow[int(t.Weekday())]
+	switch {
+	case c.domAny && c.dowAny:
+		return true
+	case c.domAny:
+		return dow
+	case c.dowAny:
+		return dom
+	}
+	return dom || dow
+}
@@ -0,0 +1,87 @@
+package importer
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCronRejectsInvalid(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"0 0 * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"a * * * *",
+		"0 0 30 2 *", // Never fires
+		"0 0 31 4,6,9,11 *",
+	} {
+		if _, err := parseCron(expr); err == nil {
+			t.Errorf("parseCron(%q) didn't return an error", expr)
+		}
+	}
+}
+
+func TestParseCronField(t *testing.T) {
+	tests := []struct {
+		field string
+		want  []int
+	}{
+		{"5", []int{5}},
+		{"1-3", []int{1, 2, 3}},
+		{"1,15", []int{1, 15}},
+		{"*/15", []int{0, 15, 30, 45}},
+		{"0-30/10", []int{0, 10, 20, 30}},
+		{"5/15", []int{5, 20, 35, 50}},
+	}
+	for _, tt := range tests {
+		set, err := parseCronField(tt.field, 0, 59)
+		if err != nil {
+			t.Errorf("parseCronField(%q) returned an error: %v", tt.field, err)
+			continue
+		}
+		if len(set) != len(tt.want) {
+			t.Errorf("parseCronField(%q) = %v, want %v", tt.field, set, tt.want)
+			continue
+		}
+		for _, v := range tt.want {
+			if !set[v] {
+				t.Errorf("parseCronField(%q) = %v, want %v", tt.field, set, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	from := time.Date(2026, time.October, 14, 9, 10, 30, 0, time.UTC) // A Wednesday
+	tests := []struct {
+		expr string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2026, time.October, 14, 9, 11, 0, 0, time.UTC)},
+		{"0 3 * * *", time.Date(2026, time.October, 15, 3, 0, 0, 0, time.UTC)},
+		{"5/15 * * * *", time.Date(2026, time.October, 14, 9, 20, 0, 0, time.UTC)},
+		{"0 0 1 * *", time.Date(2026, time.November, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 0", time.Date(2026, time.October, 18, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2026, time.October, 18, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2028, time.February, 29, 0, 0, 0, 0, time.UTC)},
+
+		// Both day fields restricted means either matches
+		{"0 0 20 * 5", time.Date(2026, time.October, 16, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		c, err := parseCron(tt.expr)
+		if err != nil {
+			t.Errorf("parseCron(%q) returned an error: %v", tt.expr, err)
+			continue
+		}
+		if got := c.next(from); !got.Equal(tt.want) {
+			t.Errorf("next(%q) = %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package importer
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Outcome of the daemon's refreshes, for the health endpoint
+type daemonStatus struct {
+	sync.Mutex
+	LastAttempt time.Time `json:"last_attempt"`
+	LastSuccess time.Time `json:"last_success"`
+	LastError   string    `json:"last_error,omitempty"`
+	NextRun     time.Time `json:"next_run"`
+}
+
+// Runs the download and import on the configured schedule until the import's context is cancelled.  A failed
+// refresh is logged, and the daemon carries on with the next one
+func runDaemon() (err error) {
+	// Work out when to run
+	var cron *cronSchedule
+	var interval time.Duration
+	switch {
+	case conf.Schedule.Cron != "":
+		cron, err = parseCron(conf.Schedule.Cron)
+	case conf.Schedule.Interval != "":
+		interval, err = time.ParseDuration(conf.Schedule.Interval)
+		if err == nil && interval <= 0 {
+			err = fmt.Errorf("The schedule interval needs to be positive")
+		}
+	default:
+		err = fmt.Errorf("Daemon mode needs either a cron or interval setting in the [schedule] section")
+	}
+	if err != nil {
+		return
+	}
+	nextRun := func(now time.Time) time.Time {
+		if cron != nil {
+			return cron.next(now)
+		}
+		return now.Add(interval)
+	}
+
+	// Serve the health endpoint, if requested
+	status := &daemonStatus{}
+	serveErr := make(chan error, 1)
+	if conf.Schedule.Listen != "" {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/health", status.serveHealth)
+		srv := &http.Server{Addr: conf.Schedule.Listen, Handler: mux}
+		go func() {
+			serveErr <- srv.ListenAndServe()
+		}()
+		defer srv.Close()
+		logger.Info("Serving the health endpoint", "listen", conf.Schedule.Listen)
+	}
+
+	// Run a refresh straight away, then on the schedule
+	for {
+		start := time.Now()
+		summary, cycleErr := runCycle()
+		status.Lock()
+		status.LastAttempt = start
+		switch {
+		case cycleErr != nil:
+			status.LastError = redactPassword(cycleErr.Error())
+			logger.Error("Refresh failed", "err", status.LastError)
+		case summary.Skipped:
+			status.LastSuccess, status.LastError = start, ""
+			logger.Info("Refresh skipped, as the Geo-IP source hasn't changed")
+		default:
+			status.LastSuccess, status.LastError = start, ""
+			logger.Info("Refresh succeeded", "ipv4_rows", summary.IPv4Rows, "ipv6_rows", summary.IPv6Rows,
+				"duration_seconds", summary.Duration)
+		}
+		status.NextRun = nextRun(time.Now())
+		next := status.NextRun
+		status.Unlock()
+
+		// parseCron rejects schedules which never match, but don't spin re-importing if one slips through
+		if next.IsZero() {
+			return fmt.Errorf("The schedule '%s' has no next run time", conf.Schedule.Cron)
+		}
+
+		logger.Info("Next refresh", "at", next.Format(time.RFC3339))
+		select {
+		case <-runCtx.Done():
+			logger.Info("Stopping")
+			return nil
+		case err = <-serveErr:
+			return fmt.Errorf("Serving the health endpoint failed: %v", err)
+		case <-time.After(time.Until(next)):
+		}
+	}
+}
+
+// Reports the outcome of the refreshes as JSON.  The status is 200 when the most recent refresh worked, and 503
+// when it failed or there hasn't been a successful one yet
+func (s *daemonStatus) serveHealth(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	if s.LastSuccess.IsZero() || s.LastError != "" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(s)
+}
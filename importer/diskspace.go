@@ -0,0 +1,15 @@
+//go:build !windows
+
+package importer
+
+import "syscall"
+
+// Returns the number of bytes available to unprivileged users on the file system holding the path
+func freeDiskSpace(path string) (free uint64, known bool, err error) {
+	var st syscall.Statfs_t
+	err = syscall.Statfs(path, &st)
+	if err != nil {
+		return
+	}
+	return uint64(st.Bavail) * uint64(st.Bsize), true, nil
+}
@@ -0,0 +1,6 @@
+package importer
+
+// Free disk space isn't checked on Windows, so this always reports the amount as unknown
+func freeDiskSpace(path string) (free uint64, known bool, err error) {
+	return 0, false, nil
+}
@@ -0,0 +1,362 @@
+package importer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// What was seen of the Geo-IP source the last time it was downloaded and imported, for telling if it's changed
+type downloadState struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+	SHA256       string `json:"sha256"`
+}
+
+// Number of times to try downloading the Geo-IP source in one run.  Each retry resumes from where the last one got
+// to, when the server supports it
+const downloadAttempts = 3
+
+// How long to wait before the first retry of an interrupted download.  This doubles for each retry after it
+var downloadRetryDelay = 5 * time.Second
+
+// Returns the path of the file holding the download state, which is kept next to the downloaded file
+func downloadStatePath() string {
+	return conf.Geo.Path + ".state"
+}
+
+// Returns the path the Geo-IP source is downloaded to, before being moved into place once it's complete.  If the
+// download is interrupted it's kept, so the next attempt can resume it
+func partPath() string {
+	return conf.Geo.Path + ".part"
+}
+
+// Returns the path of the file holding the ETag and Last-Modified values of the partly downloaded file.  A
+// download is only resumed when the server still has the same version of the file
+func partStatePath() string {
+	return partPath() + ".state"
+}
+
+// Loads the state saved by the last successful import.  If there isn't one, the state is empty
+func loadDownloadState() (state downloadState, err error) {
+	return readStateFile(downloadStatePath())
+}
+
+// Saves the download state, once the downloaded file has been imported
+func saveDownloadState(state downloadState) error {
+	return writeStateFile(downloadStatePath(), state)
+}
+
+// Reads a download state file.  If it doesn't exist, the state is empty
+func readStateFile(path string) (state downloadState, err error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(data, &state)
+	if err != nil {
+		err = fmt.Errorf("Couldn't read the Geo-IP download state file '%s': %v", path, err)
+	}
+	return
+}
+
+// Writes a download state file
+func writeStateFile(path string, state downloadState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// Removes the partly downloaded file, so the next download starts from scratch
+func removePart() {
+	for _, path := range []string{partPath(), partStatePath()} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			logger.Warn("Couldn't remove the partly downloaded Geo-IP source", "path", path, "err", err)
+		}
+	}
+}
+
+// Downloads the Geo-IP source from its URL to the configured path, unless it hasn't changed since the last
+// import.  That's worked out from the ETag and Last-Modified headers when the server supports them, and from the
+// checksum of the file when it doesn't.  With force, the source is always downloaded and counted as changed.
+// Interrupted downloads are retried, resuming from where they got to when the server supports range requests, and
+// the finished file is checked against the size the server gave and the checksum_url (if set) before it's used
+func downloadSource(force bool) (changed bool, state downloadState, err error) {
+	if conf.Geo.Path == "" {
+		err = fmt.Errorf("A path needs to be set in the [geo] section, for the downloaded file to be saved to")
+		return
+	}
+	if conf.Geo.Format == formatGeoLite2 {
+		err = fmt.Errorf("GeoLite2 sources are a directory of files, so can't be downloaded from a URL")
+		return
+	}
+	var prev downloadState
+	if !force {
+		prev, err = loadDownloadState()
+		if err != nil {
+			return
+		}
+	}
+
+	// Download the file to the .part file, retrying if the download is interrupted
+	url := strings.Replace(conf.Geo.URL, "{license_key}", conf.Geo.LicenseKey, -1)
+	delay := downloadRetryDelay
+	for attempt := 1; ; attempt++ {
+		var notModified, retry bool
+		notModified, retry, err = fetchPart(url, prev)
+		if notModified {
+			return false, prev, nil
+		}
+		if err == nil {
+			break
+		}
+		if !retry || attempt >= downloadAttempts {
+			return
+		}
+		logger.Warn("Downloading the Geo-IP source failed, retrying", "err", err, "delay", delay)
+		select {
+		case <-runCtx.Done():
+			return false, state, runCtx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+	state, err = readStateFile(partStatePath())
+	if err != nil {
+		return
+	}
+
+	// Check the finished download against the published checksum, if there is one
+	state.SHA256, err = fileSHA256(partPath())
+	if err != nil {
+		return
+	}
+	if conf.Geo.ChecksumURL != "" {
+		var want string
+		want, err = fetchChecksum(strings.Replace(conf.Geo.ChecksumURL, "{license_key}", conf.Geo.LicenseKey, -1))
+		if err != nil {
+			return
+		}
+		if !strings.EqualFold(want, state.SHA256) {
+			removePart()
+			err = fmt.Errorf("The downloaded Geo-IP source has SHA-256 checksum %s, but %s was expected",
+				state.SHA256, want)
+			return
+		}
+	}
+
+	// Servers without ETag or Last-Modified support send the whole file every time, so compare the contents too
+	if state.SHA256 == prev.SHA256 {
+		removePart()
+		return false, state, nil
+	}
+	err = os.Rename(partPath(), conf.Geo.Path)
+	if err != nil {
+		return
+	}
+	removePart()
+	logger.Debug("Downloaded the Geo-IP source", "path", conf.Geo.Path)
+	return true, state, nil
+}
+
+// Makes one attempt at downloading the Geo-IP source to the .part file, resuming the download already there when
+// the server still has the same version of the file.  notModified is set when the server says the file hasn't
+// changed since the prev download.  retry is set when the download failed in a way which is worth trying again,
+// such as the connection dropping
+func fetchPart(url string, prev downloadState) (notModified, retry bool, err error) {
+	// Resume the partly downloaded file, if there's one from the same version of the file
+	partial, err := readStateFile(partStatePath())
+	if err != nil {
+		return
+	}
+	var offset int64
+	if fi, statErr := os.Stat(partPath()); statErr == nil && (partial.ETag != "" || partial.LastModified != "") {
+		offset = fi.Size()
+	}
+
+	// Ask for the file, only if it's changed when the last version is known
+	req, err := http.NewRequestWithContext(runCtx, http.MethodGet, url, nil)
+	if err != nil {
+		return
+	}
+	if prev.ETag != "" {
+		req.Header.Set("If-None-Match", prev.ETag)
+	}
+	if prev.LastModified != "" {
+		req.Header.Set("If-Modified-Since", prev.LastModified)
+	}
+	if offset > 0 {
+		// If-Range makes the server send the whole file instead when it's changed since the part was downloaded
+		validator := partial.ETag
+		if validator == "" {
+			validator = partial.LastModified
+		}
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		req.Header.Set("If-Range", validator)
+	}
+	client := http.Client{Timeout: time.Hour}
+	logger.Info("Downloading the Geo-IP source", "url", conf.Geo.URL, "offset", offset)
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, runCtx.Err() == nil, err
+	}
+	defer resp.Body.Close()
+
+	// Work out where the data being sent goes in the file
+	total := resp.ContentLength
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	switch {
+	case resp.StatusCode == http.StatusNotModified:
+		removePart()
+		notModified = true
+		return
+	case resp.StatusCode == http.StatusPartialContent && offset > 0:
+		var start int64
+		start, total, err = parseContentRange(resp.Header.Get("Content-Range"))
+		if err == nil && start != offset {
+			err = fmt.Errorf("the server sent the file from byte %d, instead of %d", start, offset)
+		}
+		if err != nil {
+			removePart()
+			return false, true, fmt.Errorf("Resuming the Geo-IP source download failed: %v", err)
+		}
+		flags = os.O_WRONLY | os.O_APPEND
+		logger.Info("Resuming the Geo-IP source download", "offset", offset)
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		// The partly downloaded file is no use, so start again
+		removePart()
+		return false, true, fmt.Errorf("The server couldn't resume the Geo-IP source download: %s", resp.Status)
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		err = fmt.Errorf("Downloading the Geo-IP source failed: %s", resp.Status)
+		return
+	default:
+		// The whole file is being sent.  It's only worth keeping if it's interrupted when the server supports
+		// resuming it, and can tell if the file has changed in the meantime
+		offset = 0
+		partial = downloadState{ETag: resp.Header.Get("ETag"), LastModified: resp.Header.Get("Last-Modified")}
+		resumable := resp.Header.Get("Accept-Ranges") == "bytes" && (partial.ETag != "" || partial.LastModified != "")
+		if !resumable {
+			defer func() {
+				if err != nil {
+					removePart()
+				}
+			}()
+		}
+		err = writeStateFile(partStatePath(), partial)
+		if err != nil {
+			return
+		}
+	}
+
+	// Save it to the .part file alongside the destination, so a failed download doesn't leave a partial file in
+	// its place
+	if resp.ContentLength > 0 {
+		err = checkFreeDiskSpace(filepath.Dir(conf.Geo.Path), uint64(resp.ContentLength))
+		if err != nil {
+			return
+		}
+	}
+	f, err := os.OpenFile(partPath(), flags, 0644)
+	if err != nil {
+		return
+	}
+	_, err = io.Copy(f, resp.Body)
+	closeErr := f.Close()
+	if err != nil {
+		return false, runCtx.Err() == nil, fmt.Errorf("Downloading the Geo-IP source was interrupted: %v", err)
+	}
+	if closeErr != nil {
+		return false, false, closeErr
+	}
+
+	// Make sure the whole file arrived
+	if total >= 0 {
+		var fi os.FileInfo
+		fi, err = os.Stat(partPath())
+		if err != nil {
+			return
+		}
+		if fi.Size() != total {
+			removePart()
+			return false, true, fmt.Errorf("The downloaded Geo-IP source is %d bytes, but the server said it's %d",
+				fi.Size(), total)
+		}
+	}
+	return
+}
+
+// Parses a Content-Range header, eg "bytes 1000-1999/2000", returning the first byte sent and the size of the
+// whole file.  The size is -1 when the server doesn't know it
+func parseContentRange(header string) (start, total int64, err error) {
+	var end int64
+	var size string
+	_, err = fmt.Sscanf(header, "bytes %d-%d/%s", &start, &end, &size)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid Content-Range '%s'", header)
+	}
+	if size == "*" {
+		return start, -1, nil
+	}
+	total, err = strconv.ParseInt(size, 10, 64)
+	if err != nil {
+		err = fmt.Errorf("invalid Content-Range '%s'", header)
+	}
+	return
+}
+
+// Returns the SHA-256 checksum of a file, in hex
+func fileSHA256(path string) (sum string, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	hash := sha256.New()
+	_, err = io.Copy(hash, f)
+	if err != nil {
+		return
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Downloads a checksum file, returning the SHA-256 checksum in it.  These are in the format written by
+// sha256sum, so the checksum is the first field
+func fetchChecksum(url string) (sum string, err error) {
+	req, err := http.NewRequestWithContext(runCtx, http.MethodGet, url, nil)
+	if err != nil {
+		return
+	}
+	client := http.Client{Timeout: time.Minute}
+	resp, err := client.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		err = fmt.Errorf("Downloading the Geo-IP source checksum failed: %s", resp.Status)
+		return
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if err != nil {
+		return
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 || len(fields[0]) != sha256.Size*2 {
+		err = fmt.Errorf("The Geo-IP source checksum file doesn't start with a SHA-256 checksum")
+		return
+	}
+	return fields[0], nil
+}
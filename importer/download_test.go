@@ -0,0 +1,106 @@
+package importer
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Serves a file which supports range requests.  The first request is cut off half way through, as a dropped
+// connection would be.  The Range headers of the requests are recorded
+type flakyServer struct {
+	data []byte
+
+	mu     sync.Mutex
+	ranges []string
+}
+
+func (s *flakyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.ranges = append(s.ranges, r.Header.Get("Range"))
+	first := len(s.ranges) == 1
+	s.mu.Unlock()
+	w.Header().Set("ETag", `"v1"`)
+	if first {
+		w.Header().Set("Accept-Ranges", "bytes")
+		w.Header().Set("Content-Length", fmt.Sprint(len(s.data)))
+		w.Write(s.data[:len(s.data)/2])
+		panic(http.ErrAbortHandler)
+	}
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(s.data))
+}
+
+// Points the Geo-IP settings at a server, downloading to a temporary directory
+func withDownloadServer(t *testing.T, handler http.Handler) *httptest.Server {
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	oldConf, oldDelay := conf, downloadRetryDelay
+	t.Cleanup(func() { conf, downloadRetryDelay = oldConf, oldDelay })
+	conf = Config{}
+	conf.Geo.URL, conf.Geo.Path = srv.URL, filepath.Join(t.TempDir(), "geo.bin")
+	downloadRetryDelay = time.Millisecond
+	return srv
+}
+
+func TestDownloadResumes(t *testing.T) {
+	withTestSecrets(t, slog.LevelError)
+	src := &flakyServer{data: bytes.Repeat([]byte("0123456789"), 10000)}
+	withDownloadServer(t, src)
+	sum := sha256.Sum256(src.data)
+	checksum := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s  geo.bin\n", hex.EncodeToString(sum[:]))
+	}))
+	defer checksum.Close()
+	conf.Geo.ChecksumURL = checksum.URL
+
+	changed, state, err := downloadSource(false)
+	if err != nil {
+		t.Fatalf("downloadSource() failed: %v", err)
+	}
+	got, err := os.ReadFile(conf.Geo.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !changed || !bytes.Equal(got, src.data) {
+		t.Errorf("downloadSource() = %v, with %d of the %d bytes", changed, len(got), len(src.data))
+	}
+	if state.ETag != `"v1"` || state.SHA256 != hex.EncodeToString(sum[:]) {
+		t.Errorf("downloadSource() state = %+v", state)
+	}
+	want := []string{"", fmt.Sprintf("bytes=%d-", len(src.data)/2)}
+	if strings.Join(src.ranges, ",") != strings.Join(want, ",") {
+		t.Errorf("the requests asked for ranges %q, want %q", src.ranges, want)
+	}
+	if _, err = os.Stat(partPath()); !os.IsNotExist(err) {
+		t.Errorf("the .part file is still there after downloading: %v", err)
+	}
+}
+
+func TestDownloadRejectsBadChecksum(t *testing.T) {
+	withTestSecrets(t, slog.LevelError)
+	srv := withDownloadServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, ".sha256") {
+			fmt.Fprintln(w, strings.Repeat("0", 64))
+			return
+		}
+		w.Write([]byte("geo data"))
+	}))
+	conf.Geo.ChecksumURL = srv.URL + "/geo.bin.sha256"
+	_, _, err := downloadSource(false)
+	if err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Errorf("downloadSource() with the wrong checksum = %v, want a checksum error", err)
+	}
+	if _, statErr := os.Stat(conf.Geo.Path); !os.IsNotExist(statErr) {
+		t.Error("the download with the wrong checksum was moved into place")
+	}
+}
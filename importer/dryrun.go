@@ -0,0 +1,112 @@
+package importer
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Reads the Geo-IP source the same way an import does, then shows the DDL which would be run in PostgreSQL and
+// the number of rows each table would get.  Doesn't connect to PostgreSQL at all
+func runDryRun() (err error) {
+	if targetDriver() != driverPostgreSQL {
+		return fmt.Errorf("-dry-run only covers the postgresql target")
+	}
+	if upsert || conf.FDW.Server != "" {
+		return fmt.Errorf("-dry-run only covers the default replace mode, not -upsert or foreign tables")
+	}
+	err = validateSchemaMode()
+	if err != nil {
+		return
+	}
+	err = validateOutputTables(conf.Tables)
+	if err != nil {
+		return
+	}
+	err = validateCombinedTable()
+	if err != nil {
+		return
+	}
+	closeSource, err := openSource()
+	if err != nil {
+		return
+	}
+	defer closeSource()
+
+	var imported []ipFamily
+	for _, fam := range families {
+		var has bool
+		has, err = sourceHasFamily(fam)
+		if err != nil {
+			return
+		}
+		if has {
+			imported = append(imported, fam)
+		} else if combinedTable {
+			fmt.Printf("The Geo-IP source has no %s data, so %s would have none\n", fam.name, fam.pgTable)
+		} else {
+			fmt.Printf("The Geo-IP source has no %s data, so %s would be left alone\n", fam.name, fam.pgTable)
+		}
+	}
+
+	// Read each address family's rows, checking them as the import would
+	counts := make(map[ipFamily]int)
+	for _, fam := range imported {
+		var prevRow *oneRow
+		err = readSource(fam, func(row oneRow) error {
+			if fixCountryCase {
+				normaliseCountryCase(&row)
+			}
+			validateRow(fam, row, prevRow)
+			prevRow = &row
+			counts[fam]++
+			return nil
+		})
+		if err != nil {
+			return
+		}
+	}
+
+	// Show the statements the import would run
+	fmt.Println("\n-- DDL which would be run:")
+	var statements []string
+	indexes := make(map[ipFamily][]string)
+	for _, fam := range tableFamilies(imported) {
+		staging := stagingTable(fam)
+		statements = append(statements, createLocalTableSQL(fam, staging)...)
+		var indexSQL []string
+		indexes[fam], indexSQL = lookupIndexSQL(staging)
+		statements = append(statements, indexSQL...)
+	}
+	for _, fam := range tableFamilies(imported) {
+		statements = append(statements, swapSQL(fam, indexes[fam])...)
+	}
+	for _, info := range conf.Tables {
+		fam, _ := info.family()
+		statements = append(statements, fmt.Sprintf("-- Extra %s output table %s (%s)", fam.name, info.Name,
+			strings.Join(info.Columns, ", ")))
+	}
+	for _, st := range statements {
+		if strings.HasPrefix(st, "--") {
+			fmt.Println(st)
+			continue
+		}
+		fmt.Printf("%s;\n", strings.TrimSpace(st))
+	}
+
+	// Then the row counts
+	fmt.Println("\n-- Rows which would be imported:")
+	for _, fam := range imported {
+		if combinedTable {
+			fmt.Printf("%s (%s): %d\n", fam.pgTable, fam.name, counts[fam])
+			continue
+		}
+		fmt.Printf("%s: %d\n", fam.pgTable, counts[fam])
+	}
+	if warningCount > 0 {
+		fmt.Printf("\n%d warning(s) were raised reading the source\n", warningCount)
+		if rollbackOnWarning {
+			return fmt.Errorf("The import would be rolled back due to %d warning(s)", warningCount)
+		}
+	}
+	return
+}
@@ -0,0 +1,69 @@
+package importer
+
+import (
+	"fmt"
+
+	"github.com/jackc/pgx"
+)
+
+// Creates (or refreshes) a foreign table definition pointing at an address family's country lookup table on a
+// remote server, then clears out its existing data so it can be reloaded through the foreign table.  The remote
+// table itself, and its indexes, need to already exist on the remote server
+func createForeignTable(tx *pgx.Tx, fam ipFamily) (err error) {
+	// Make sure the foreign server has been set up.  Its name is used unquoted in the SQL, so check that first
+	if !tableNameRegex.MatchString(conf.FDW.Server) {
+		return fmt.Errorf("Invalid foreign server name '%s'", conf.FDW.Server)
+	}
+	var exists bool
+	dbQuery := `
+		SELECT count(*) > 0
+		FROM pg_foreign_server
+		WHERE srvname = $1`
+	err = tx.QueryRow(dbQuery, conf.FDW.Server).Scan(&exists)
+	if err != nil {
+		return
+	}
+	if !exists {
+		return fmt.Errorf("Foreign server '%s' doesn't exist.  It needs to be created with CREATE SERVER first",
+			conf.FDW.Server)
+	}
+	schema, table := conf.FDW.Schema, conf.FDW.Table
+	if fam == familyIPv6 {
+		table = conf.FDW.TableV6
+	}
+	if schema == "" {
+		schema = "public"
+	}
+	if table == "" {
+		table = fam.pgTable
+	}
+
+	// Recreate the foreign table definition, in case the remote details have changed
+	logger.Info("Creating the foreign table", "table", fam.pgTable, "server", conf.FDW.Server,
+		"remote_table", schema+"."+table)
+	_, err = tx.Exec(fmt.Sprintf(`DROP FOREIGN TABLE IF EXISTS %s`, fam.pgTable))
+	if err != nil {
+		return
+	}
+	dbQuery = fmt.Sprintf(`
+		CREATE FOREIGN TABLE %[1]s (
+			ipfrom %[2]s,
+			ipto %[2]s,
+			registry text,
+			assigned bigint,
+			ctry text,
+			cntry text,
+			country text
+		)
+		SERVER %[3]s
+		OPTIONS (schema_name %[4]s, table_name %[5]s)`, fam.pgTable, fam.keyType, conf.FDW.Server, quoteLiteral(schema),
+		quoteLiteral(table))
+	_, err = tx.Exec(dbQuery)
+	if err != nil {
+		return
+	}
+
+	// Remove the existing remote data, so the import replaces it
+	_, err = tx.Exec(fmt.Sprintf(`DELETE FROM %s`, fam.pgTable))
+	return
+}
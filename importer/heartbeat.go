@@ -0,0 +1,67 @@
+package importer
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// Number of rows imported so far, for the heartbeat to report.  Updated atomically, as the heartbeat runs in
+// its own goroutine
+var rowsImported int64
+
+// Set while the rows are being copied to the target, so the heartbeat can tell a stalled copy from a phase (like
+// building the indexes) which doesn't import any rows
+var copyInProgress atomic.Bool
+
+// Logs a heartbeat message every interval until the returned function is called, which waits for it to stop.  The import's connection is busy
+// with the import, so it can't be pinged.  Instead, a copy which hasn't made any progress since the last heartbeat
+// is reported, as that's what a dropped connection looks like from here.  The connection itself is checked with
+// TCP keepalives (see heartbeatDialer), so a dead one fails the import rather than hanging it
+func startHeartbeat(interval time.Duration) (stop func()) {
+	done, finished := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(finished)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		last := atomic.LoadInt64(&rowsImported)
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				rows := atomic.LoadInt64(&rowsImported)
+				if copyInProgress.Load() && rows == last {
+					logger.Warn("Still importing, but no rows have been imported since the last heartbeat, so the "+
+						"connection may have stalled", "rows", rows, "interval", interval)
+				} else {
+					logger.Info("Still importing", "rows", rows)
+				}
+				last = rows
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-finished
+	}
+}
+
+// Returns a dialer whose connections send TCP keepalives once they've been idle for the heartbeat interval.  After
+// three unanswered probes a third of the interval apart the connection is closed, so a server or network which has
+// gone away fails the import within about twice the interval
+func heartbeatDialer(interval time.Duration) *net.Dialer {
+	probeInterval := interval / 3
+	if probeInterval < time.Second {
+		probeInterval = time.Second
+	}
+	return &net.Dialer{
+		Timeout: 30 * time.Second,
+		KeepAliveConfig: net.KeepAliveConfig{
+			Enable:   true,
+			Idle:     interval,
+			Interval: probeInterval,
+			Count:    3,
+		},
+	}
+}
@@ -0,0 +1,741 @@
+package importer
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode"
+
+	"github.com/jackc/pgx"
+	"github.com/jackc/pgx/pgtype"
+)
+
+// PostgreSQL error code for a lock timeout
+const lockNotAvailable = "55P03"
+
+// Summary of an import run.  This is also what's sent to the webhook
+type Result struct {
+	Success      bool            `json:"success"`
+	Error        string          `json:"error,omitempty"` // Why the import failed, with any secrets masked
+	Source       string          `json:"source"`
+	IPv4Rows     int             `json:"ipv4_rows"`
+	IPv6Rows     int             `json:"ipv6_rows"`
+	Warnings     []string        `json:"warnings"`      // Data problems found in the source, which didn't stop the import
+	WarningCount int             `json:"warning_count"` // Number of warnings.  Only the first 100 are kept in Warnings
+	StartTime    time.Time       `json:"start_time"`
+	Duration     float64         `json:"duration_seconds"`
+	Phases       []PhaseDuration `json:"phases"`
+
+	// Set when the Geo-IP source hasn't changed since the last import, so it wasn't imported again
+	Skipped bool `json:"-"`
+
+	// Set when the main tables were committed but some of the extra output tables weren't
+	Partial           bool     `json:"partial,omitempty"`
+	UncommittedTables []string `json:"uncommitted_tables,omitempty"`
+
+	phaseStart time.Time
+	inPhase    bool
+}
+
+// Imports the Geo-IP source data into PostgreSQL, or the other configured output target
+func runImport(summary *Result) (err error) {
+	err = validateTarget()
+	if err != nil {
+		return
+	}
+	err = validateCombinedTable()
+	if err != nil {
+		return
+	}
+	if targetDriver() != driverPostgreSQL {
+		return importToTarget(summary)
+	}
+	defer summary.endPhase()
+
+	// Open the Geo-IP database, for country lookups
+	summary.startPhase("Open source")
+	closeSource, err := openSource()
+	if err != nil {
+		return
+	}
+	defer closeSource()
+
+	// Check any extra output tables before connecting
+	err = validateOutputTables(conf.Tables)
+	if err != nil {
+		return
+	}
+
+	// Foreign tables don't support ON CONFLICT, so can't be merged into
+	if upsert && conf.FDW.Server != "" {
+		return fmt.Errorf("-upsert can't be used with a foreign table")
+	}
+
+	// Connect to PG
+	summary.startPhase("Create tables")
+	err = connectPG()
+	if err != nil {
+		return
+	}
+	defer pg.Close()
+
+	// Begin PostgreSQL transaction
+	tx, err := pg.BeginEx(runCtx, nil)
+	if err != nil {
+		return
+	}
+	// The transaction is automatically rolled back if the function exits without committing
+	w := &pgWriter{tx: tx}
+	defer w.Close()
+
+	// Don't wait forever if something like a long running query holds a lock on the existing table
+	lockTimeout, err := setLockTimeout(tx)
+	if err != nil {
+		return
+	}
+
+	// Make sure the range columns can be created, if asked for
+	err = checkSchemaMode(tx)
+	if err != nil {
+		return
+	}
+
+	// Work out which address families the source has data for
+	var imported []ipFamily
+	for _, fam := range families {
+		var has bool
+		has, err = sourceHasFamily(fam)
+		if err != nil {
+			return
+		}
+		if has {
+			imported = append(imported, fam)
+		} else {
+			logger.Info("The Geo-IP source has no data for the address family, so skipping it", "family", fam.name,
+				"table", fam.pgTable)
+		}
+	}
+
+	// Create the tables to hold the country lookup data.  New data is loaded into staging tables, which are swapped
+	// in once verified.  For foreign tables, the data lives on the remote server
+	created := make(map[ipFamily]bool)
+	for _, fam := range imported {
+		switch {
+		case conf.FDW.Server != "":
+			err = createForeignTable(tx, fam)
+		case upsert:
+			created[fam], err = createUpsertTables(tx, fam)
+		default:
+			created[fam] = true
+			err = w.CreateSchema(fam)
+		}
+		if err != nil {
+			return
+		}
+	}
+
+	// Start the extra output tables, each in its own transaction.  These hold a subset of the IPv4 or IPv6 data.
+	// The ones for an address family the source doesn't have are left alone, like the main tables
+	var outputs []*outputTable
+	defer func() {
+		for _, t := range outputs {
+			t.rollback()
+		}
+	}()
+	for _, info := range conf.Tables {
+		fam, _ := info.family()
+		if !slices.Contains(imported, fam) {
+			logger.Info("The Geo-IP source has no data for the output table's address family, so skipping it",
+				"family", fam.name, "table", info.Name)
+			continue
+		}
+		var t *outputTable
+		t, err = startOutputTable(info, fam)
+		if t != nil {
+			outputs = append(outputs, t)
+		}
+		if err != nil {
+			return
+		}
+	}
+	familyOutputs := func(fam ipFamily) (list []*outputTable) {
+		for _, t := range outputs {
+			if t.fam == fam {
+				list = append(list, t)
+			}
+		}
+		return
+	}
+	closeOutputs := func(list []*outputTable) {
+		for _, t := range list {
+			if t.rows != nil {
+				close(t.rows)
+				t.rows = nil
+			}
+		}
+	}
+	defer func() { closeOutputs(outputs) }()
+
+	// Let people know the import is still alive, if requested
+	if heartbeatInterval > 0 {
+		stopHeartbeat := startHeartbeat(heartbeatInterval)
+		defer stopHeartbeat()
+	}
+
+	for _, fam := range imported {
+		// Import the IP country lookup data from the Geo-IP source to PG
+		famOutputs := familyOutputs(fam)
+		summary.startPhase("Import " + fam.name)
+		err = importFamily(w, fam, famOutputs)
+		closeOutputs(famOutputs)
+		if err != nil {
+			return
+		}
+		if upsert {
+			err = mergeUpsert(tx, fam)
+			if err != nil {
+				return
+			}
+		}
+
+		// Create appropriate indexes on the new PG country lookup data.  Foreign tables can't have indexes, so
+		// those are left to the remote server.  Existing tables being merged into already have theirs
+		if created[fam] {
+			summary.startPhase("Index " + fam.name)
+			logger.Info("Creating the indexes", "family", fam.name)
+			err = w.BuildIndexes(fam)
+			if err != nil {
+				return
+			}
+		}
+
+		// Verify the same number of entries in both the source and PG tables
+		summary.startPhase("Verify " + fam.name)
+		var count int
+		count, err = w.Verify(fam)
+		if err != nil {
+			return
+		}
+		if checksumCheck {
+			err = checksumVerify(tx, fam, newDataTable(fam))
+			if err != nil {
+				return
+			}
+		}
+		if sampleCheckCount > 0 {
+			err = sampleRoundTrip(tx, fam, newDataTable(fam), sampleCheckCount)
+			if err != nil {
+				return
+			}
+		}
+		for _, t := range famOutputs {
+			err = t.finish(count)
+			if err != nil {
+				return
+			}
+		}
+		if fam == familyIPv4 {
+			summary.IPv4Rows = count
+		} else {
+			summary.IPv6Rows = count
+		}
+	}
+
+	// Make sure lookups on the new data will use an index.  These checks run against the staging tables, so the
+	// live ones aren't locked while they run
+	summary.startPhase("Checks")
+	if assertIndex {
+		for _, fam := range imported {
+			err = assertIndexUsed(tx, fam, newDataTable(fam))
+			if err != nil {
+				return
+			}
+		}
+	}
+
+	// Check random addresses resolve to the same country in both the source and PG
+	if shuffleCheckCount > 0 && slices.Contains(imported, familyIPv4) {
+		logger.Info("Checking random addresses resolve the same in the source and PG", "addresses",
+			shuffleCheckCount)
+		var mismatches int
+		mismatches, err = shuffleCheck(tx, shuffleCheckCount, newDataTable(familyIPv4))
+		if err != nil {
+			return
+		}
+		logger.Info("Shuffle check complete", "addresses", shuffleCheckCount, "mismatches", mismatches)
+	}
+
+	// If requested, treat any warnings raised during the import as fatal
+	if warningCount > 0 {
+		logger.Warn("Warnings were raised during the import", "warnings", warningCount)
+		if rollbackOnWarning {
+			return fmt.Errorf("Import rolled back due to %d warning(s)", warningCount)
+		}
+	}
+
+	// Swap the new tables into place, now they're populated and checked.  This takes an exclusive lock on the live
+	// tables, so it's left until just before committing
+	if !upsert && conf.FDW.Server == "" {
+		summary.startPhase("Swap tables")
+		for _, fam := range tableFamilies(imported) {
+			err = swapTables(tx, fam, lockTimeout)
+			if err != nil {
+				return
+			}
+		}
+	}
+
+	// Commit PostgreSQL transaction, now the data has been verified
+	summary.startPhase("Commit")
+	err = w.Commit()
+	if err != nil {
+		return
+	}
+
+	// Commit the extra output tables too.  They were verified along with the IPv4 data, but each is in its own
+	// transaction, so one failing here can't undo the main tables.  The rest are still committed, and the ones
+	// which failed are reported so the partial state is clear
+	var failed []string
+	for _, t := range outputs {
+		if cErr := t.commit(); cErr != nil {
+			logger.Error("Committing an output table failed", "table", t.info.Name, "err", cErr)
+			failed = append(failed, t.info.Name)
+		}
+	}
+	if len(failed) > 0 {
+		summary.Partial = true
+		summary.UncommittedTables = failed
+		return fmt.Errorf("The country lookup tables were replaced, but committing output table(s) %s failed, so "+
+			"they still hold the previous data", strings.Join(failed, ", "))
+	}
+	return
+}
+
+// Columns of the country lookup tables, in the order they're copied
+var copyColumns = []string{"ipfrom", "ipto", "registry", "assigned", "ctry", "cntry", "country"}
+
+// Feeds the rows sent on a channel into pgx's CopyFrom, which pulls rows rather than having them pushed to it
+type copySource struct {
+	rows   <-chan oneRow
+	values func(row oneRow) []interface{}
+	cur    oneRow
+}
+
+func (c *copySource) Next() (ok bool) {
+	c.cur, ok = <-c.rows
+	return
+}
+
+func (c *copySource) Values() ([]interface{}, error) {
+	return c.values(c.cur), nil
+}
+
+func (c *copySource) Err() error {
+	return nil
+}
+
+// Reads the records for an address family from the Geo-IP source, and streams them to the writer.  Each record is
+// also sent to the given output tables
+func importFamily(w writer, fam ipFamily, outputs []*outputTable) (err error) {
+	logger.Info("Importing from the Geo-IP source", "family", fam.name, "target", targetDriver())
+	var prevRow *oneRow
+	var adjusted, count int
+
+	// The total is only needed for the progress messages' ETA
+	var total int
+	if progressEvery > 0 {
+		total, err = countSource(fam)
+		if err != nil {
+			return
+		}
+	}
+	start := time.Now()
+
+	// Read the source in its own goroutine, as the SQLite reader calls back with each row while the writers (eg
+	// pgx's CopyFrom) want to ask for them.  If writing fails part way through, closing stop tells the reader to
+	// finish up early
+	rows := make(chan oneRow, 1000)
+	stop := make(chan struct{})
+	readErr := make(chan error, 1)
+	go func() {
+		readErr <- readSource(fam, func(row oneRow) error {
+			// Stop when the import is cancelled
+			if err := runCtx.Err(); err != nil {
+				return err
+			}
+
+			// Fix up the case of the country codes, if requested
+			if fixCountryCase && normaliseCountryCase(&row) {
+				adjusted++
+			}
+
+			// Check the row for data problems
+			validateRow(fam, row, prevRow)
+			prevRow = &row
+
+			atomic.AddInt64(&rowsImported, 1)
+			count++
+			if progressEvery > 0 && count%progressEvery == 0 {
+				logProgress(fam, count, total, start)
+			}
+			for _, t := range outputs {
+				t.rows <- row
+			}
+			select {
+			case rows <- row:
+				return nil
+			case <-stop:
+				return errStopReading
+			case <-runCtx.Done():
+				return runCtx.Err()
+			}
+		})
+		close(rows)
+	}()
+
+	// Write the rows to the target
+	copyInProgress.Store(true)
+	written, err := w.BulkInsert(fam, rows)
+	copyInProgress.Store(false)
+	close(stop)
+	if rErr := <-readErr; rErr != nil && rErr != errStopReading && err == nil {
+		err = rErr
+	}
+	if err != nil {
+		return
+	}
+	logger.Debug("Wrote the rows", "family", fam.name, "rows", written, "target", targetDriver())
+	if fixCountryCase {
+		logger.Info("Adjusted the country case", "family", fam.name, "rows", adjusted)
+	}
+	return
+}
+
+// Checks the PG table for an address family has the same number of rows as the Geo-IP source, returning the count
+func verifyRowCount(tx *pgx.Tx, fam ipFamily, table string) (sRowCount int, err error) {
+	var pgRowCount int
+	sRowCount, err = countSource(fam)
+	if err != nil {
+		return
+	}
+	if fastVerify {
+		// Use the planner's estimate of the row count, which is much quicker than counting on huge tables
+		pgRowCount, err = estimateRowCount(tx, table)
+		if err != nil {
+			err = fmt.Errorf("error when estimating rows in the pg table: %v", err)
+			return
+		}
+		if !withinTolerance(pgRowCount, sRowCount) {
+			err = fmt.Errorf("Mismatching %s row counts after import.  Source: %d, PostgreSQL: ~%d (approximate)",
+				fam.name, sRowCount, pgRowCount)
+			return
+		}
+		logger.Info("Row counts match (approximate)", "family", fam.name, "source", sRowCount, "postgresql",
+			pgRowCount)
+		return
+	}
+	dbQuery := fmt.Sprintf(`SELECT count(*) FROM %s WHERE %s`, table, familyCondition(fam))
+	err = tx.QueryRowEx(runCtx, dbQuery, nil).Scan(&pgRowCount)
+	if err != nil {
+		err = fmt.Errorf("error when counting rows in the pg table: %v", err)
+		return
+	}
+	if pgRowCount != sRowCount {
+		err = fmt.Errorf("Mismatching %s row counts after import.  Source: %d, PostgreSQL: %d", fam.name, sRowCount,
+			pgRowCount)
+	}
+	return
+}
+
+// Creates a new (empty) country lookup table for the address family, dropping any existing table of the same name
+func createLocalTable(tx *pgx.Tx, fam ipFamily, table string) (err error) {
+	logger.Info("Creating the new data table", "family", fam.name, "table", table)
+	for _, dbQuery := range createLocalTableSQL(fam, table) {
+		_, err = tx.Exec(dbQuery)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Returns the statements createLocalTable runs.  These are also what -dry-run shows
+func createLocalTableSQL(fam ipFamily, table string) []string {
+	statements := []string{
+		// Drop any leftover table of the same name
+		fmt.Sprintf(`DROP TABLE IF EXISTS %s`, table),
+		createTableSQL(fam, table),
+	}
+
+	// Document the table and its columns, for people browsing the schema
+	return append(statements, commentSQL(table, importComment())...)
+}
+
+// Returns the name of a country lookup table's primary key, and the index behind it
+func primaryKeyName(table string) string {
+	return table + "_pk"
+}
+
+// Returns the CREATE TABLE statement for an address family's country lookup table
+func createTableSQL(fam ipFamily, table string) string {
+	if combinedTable {
+		return createCombinedTableSQL(table)
+	}
+	return fmt.Sprintf(`
+		CREATE TABLE %[1]s (
+			ipfrom %[2]s constraint %[4]s primary key,
+			ipto %[2]s,
+			registry text,
+			assigned bigint,
+			ctry text,
+			cntry text,
+			country text%[3]s
+		)`, table, fam.keyType, rangeColumnDDL(fam), primaryKeyName(table))
+}
+
+// Returns the comment to place on the country lookup tables, defaulting to the source and import time
+func importComment() string {
+	if tableComment == "" {
+		tableComment = fmt.Sprintf("IP country lookup data imported from %s at %s", conf.Geo.Path, time.Now().UTC().Format(time.RFC3339))
+	}
+	return tableComment
+}
+
+// Returns an ipfrom or ipto value in the form needed for a query parameter of the address family's PG column type
+func (f ipFamily) keyArg(n ipNum) interface{} {
+	if f == familyIPv4 {
+		return int64(n.lo)
+	}
+	return n.String()
+}
+
+// Returns an ipfrom or ipto value for sending to PG with COPY.  That uses the binary format, in which pgx sends a
+// string's bytes as they are, so the IPv6 values need to go as numerics rather than as their decimal strings
+func (f ipFamily) copyArg(n ipNum) interface{} {
+	if f == familyIPv4 {
+		return int64(n.lo)
+	}
+	return &pgtype.Numeric{Int: n.big(), Status: pgtype.Present}
+}
+
+// Sets the lock timeout for the transaction, using the value from the config file if present
+func setLockTimeout(tx *pgx.Tx) (lockTimeout time.Duration, err error) {
+	lockTimeout = defaultLockTimeout
+	if conf.Pg.LockTimeout != "" {
+		lockTimeout, err = time.ParseDuration(conf.Pg.LockTimeout)
+		if err != nil {
+			err = fmt.Errorf("Invalid lock_timeout '%s': %v", conf.Pg.LockTimeout, err)
+			return
+		}
+	}
+	_, err = tx.Exec(fmt.Sprintf(`SET LOCAL lock_timeout = %d`, lockTimeout.Nanoseconds()/int64(time.Millisecond)))
+	return
+}
+
+// Parses a comma separated list of indexes for Options.Indexes, in the form given to -indexes.  Each index is a
+// column name, or several column names joined with "+" for a multi-column index.  With noIPTo, the single column
+// index on ipto is left out.  Duplicates are rejected here, as they'd otherwise only fail when the second index is
+// created, after the whole source has been loaded
+func ParseIndexSpecs(list string, noIPTo bool) (specs [][]string, err error) {
+	seen := make(map[string]bool)
+	for _, spec := range strings.Split(list, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		cols := strings.Split(spec, "+")
+		seenCol := make(map[string]bool)
+		for _, c := range cols {
+			if _, ok := columnTypes[c]; !ok {
+				err = fmt.Errorf("Unknown column '%s' in index '%s'", c, spec)
+				return
+			}
+			if seenCol[c] {
+				err = fmt.Errorf("Column '%s' is given more than once in index '%s'", c, spec)
+				return
+			}
+			seenCol[c] = true
+		}
+		key := strings.Join(cols, "+")
+		if seen[key] {
+			err = fmt.Errorf("Index '%s' is given more than once", spec)
+			return
+		}
+		seen[key] = true
+		if len(cols) == 1 && cols[0] == "ipfrom" {
+			err = fmt.Errorf("ipfrom is already indexed by the primary key")
+			return
+		}
+		if noIPTo && len(cols) == 1 && cols[0] == "ipto" {
+			continue
+		}
+		specs = append(specs, cols)
+	}
+	return
+}
+
+// Creates the requested indexes on a table.  When the table only has some of the columns, indexes using the
+// others are skipped
+func createIndexes(tx *pgx.Tx, table string, columns []string) (err error) {
+	for _, dbQuery := range indexSQL(table, columns) {
+		_, err = tx.ExecEx(runCtx, dbQuery, nil)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Returns the names of the indexes on a country lookup table, and the statements creating them other than the
+// primary key: the configured indexes, and the range column's index if there is one
+func lookupIndexSQL(table string) (names, statements []string) {
+	names, statements = indexDefs(table, nil)
+	names = append([]string{primaryKeyName(table)}, names...)
+	if conf.Pg.SchemaMode != "" {
+		names = append(names, rangeIndexName(table))
+		statements = append(statements, rangeIndexSQL(table))
+	}
+	return
+}
+
+// Returns the CREATE INDEX statements for the configured indexes whose columns are all in the table.  A nil list
+// of columns means the table has all of them
+func indexSQL(table string, columns []string) (statements []string) {
+	_, statements = indexDefs(table, columns)
+	return
+}
+
+// Returns the names of the configured indexes whose columns are all in the table, and the statements creating them
+func indexDefs(table string, columns []string) (names, statements []string) {
+	has := func(c string) bool {
+		if columns == nil {
+			return true
+		}
+		for _, col := range columns {
+			if col == c {
+				return true
+			}
+		}
+		return false
+	}
+nextIndex:
+	for _, cols := range indexSpecs {
+		for _, c := range cols {
+			if !has(c) {
+				continue nextIndex
+			}
+		}
+		name := fmt.Sprintf("%s_%s_index", table, strings.Join(cols, "_"))
+		names = append(names, name)
+		statements = append(statements, fmt.Sprintf(`CREATE INDEX %s ON %s (%s)`, name, table,
+			indexColumns(cols)))
+	}
+	return
+}
+
+// Places a comment on the given table, and on each of its columns
+func commentTable(tx *pgx.Tx, table, comment string) (err error) {
+	for _, dbQuery := range commentSQL(table, comment) {
+		_, err = tx.Exec(dbQuery)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Returns the COMMENT ON statements for a table and each of its columns
+func commentSQL(table, comment string) []string {
+	statements := []string{fmt.Sprintf(`COMMENT ON TABLE %s IS %s`, table, quoteLiteral(comment))}
+	if combinedTable {
+		statements = append(statements, fmt.Sprintf(`COMMENT ON COLUMN %s.family IS %s`, table,
+			quoteLiteral("IP address family of the range, either 4 or 6")))
+	}
+	for _, c := range columnComments {
+		statements = append(statements, fmt.Sprintf(`COMMENT ON COLUMN %s.%s IS %s`, table, c.name,
+			quoteLiteral(c.comment)))
+	}
+	return statements
+}
+
+// Quotes a string for use as a PostgreSQL string literal.  Needed for statements like COMMENT ON, which don't
+// accept bind parameters
+func quoteLiteral(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
+
+// Checks a data record for invalid country codes, bad ranges, and overlaps with the previous record.  Sources
+// without 3 letter country codes (eg IP2Location) leave cntry empty, so that's not treated as invalid
+func validateRow(fam ipFamily, row oneRow, prevRow *oneRow) {
+	if row.ipTo.cmp(row.ipFrom) < 0 {
+		warn("Bad range, ipto (%v) is lower than ipfrom (%v)\n", row.ipTo, row.ipFrom)
+	}
+
+	// The range size limits are in IPv4 terms, as IPv6 allocations are huge by design
+	if fam == familyIPv4 {
+		size := row.ipTo.sub(row.ipFrom).inc()
+		if limit := rangeSizeLimit(row.registry); size.cmp(ipNumFromUint64(uint64(limit))) > 0 {
+			warn("Implausibly large range %v - %v (%v addresses, limit is %d) assigned to '%s'\n", row.ipFrom,
+				row.ipTo, size, limit, row.ctry)
+		}
+	}
+	if prevRow != nil && row.ipFrom.cmp(prevRow.ipTo) <= 0 {
+		warn("Overlapping ranges. ipfrom: %v overlaps previous range %v - %v\n", row.ipFrom, prevRow.ipFrom, prevRow.ipTo)
+	}
+	if !ctryRegex.MatchString(row.ctry) {
+		warn("Invalid 2 letter country code '%s'. ipfrom: %v\n", row.ctry, row.ipFrom)
+	}
+	if row.cntry != "" && !cntryRegex.MatchString(row.cntry) {
+		warn("Invalid 3 letter country code '%s'. ipfrom: %v\n", row.cntry, row.ipFrom)
+	}
+}
+
+// Upper cases the country codes of a data record, and title cases its country name if requested.  Returns true
+// if anything was changed
+func normaliseCountryCase(row *oneRow) bool {
+	orig := *row
+	row.ctry = strings.ToUpper(row.ctry)
+	row.cntry = strings.ToUpper(row.cntry)
+	if countryNameCase == "title" {
+		row.country = titleCase(row.country)
+	}
+	return *row != orig
+}
+
+// Upper cases the first letter of each word, and lower cases the rest
+func titleCase(s string) string {
+	words := strings.Fields(strings.ToLower(s))
+	for i, w := range words {
+		r := []rune(w)
+		r[0] = unicode.ToUpper(r[0])
+		words[i] = string(r)
+	}
+	return strings.Join(words, " ")
+}
+
+// Returns the largest number of addresses a range from the given registry should cover
+func rangeSizeLimit(registry string) int {
+	if maxRangeSize > 0 {
+		return maxRangeSize
+	}
+	if limit, ok := registryMaxRangeSizes[strings.ToLower(registry)]; ok {
+		return limit
+	}
+	return defaultMaxRangeSize
+}
+
+// Number of warnings kept for the import summary.  A badly broken source can raise one for every row, which would
+// make for a huge webhook message, so past this they're only counted (and logged)
+const maxStoredWarnings = 100
+
+// Logs a warning, and records it so the end of the import knows one was raised
+func warn(format string, args ...interface{}) {
+	msg := redactPassword(fmt.Sprintf(format, args...))
+	logger.Warn(strings.TrimSuffix(msg, "\n"))
+	warningCount++
+	if len(warnings) < maxStoredWarnings {
+		warnings = append(warnings, msg)
+	}
+}
@@ -0,0 +1,135 @@
+package importer
+
+import (
+	"bytes"
+	"log/slog"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/pgtype"
+)
+
+func TestParseIndexSpecs(t *testing.T) {
+	specs, err := ParseIndexSpecs("ipto, ctry+cntry,country", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{{"ipto"}, {"ctry", "cntry"}, {"country"}}
+	if !reflect.DeepEqual(specs, want) {
+		t.Errorf("ParseIndexSpecs() = %v, want %v", specs, want)
+	}
+
+	// -no-ipto-index drops the plain ipto index, but not multi-column ones using it
+	specs, err = ParseIndexSpecs("ipto,ipto+ctry", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = [][]string{{"ipto", "ctry"}}
+	if !reflect.DeepEqual(specs, want) {
+		t.Errorf("ParseIndexSpecs() with noIPTo = %v, want %v", specs, want)
+	}
+}
+
+func TestParseIndexSpecsRejectsInvalid(t *testing.T) {
+	for _, list := range []string{
+		"nosuchcolumn",
+		"ipfrom",
+		"ctry,ctry",
+		"ipto,ipto",
+		"ctry+cntry, ctry+cntry",
+		"ctry+ctry",
+	} {
+		if _, err := ParseIndexSpecs(list, false); err == nil {
+			t.Errorf("ParseIndexSpecs(%q) didn't return an error", list)
+		}
+	}
+}
+
+// A buffer which can be written by one goroutine while another reads it
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestHeartbeatReportsStalledCopy(t *testing.T) {
+	oldLogger := logger
+	defer func() { logger = oldLogger }()
+	var out syncBuffer
+	logger = slog.New(slog.NewTextHandler(&out, nil))
+
+	copyInProgress.Store(true)
+	defer copyInProgress.Store(false)
+	stop := startHeartbeat(10 * time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	stop()
+	if !strings.Contains(out.String(), "no rows have been imported since the last heartbeat") {
+		t.Errorf("the heartbeat didn't report the stalled copy:\n%s", out.String())
+	}
+}
+
+func TestValidateOutputTables(t *testing.T) {
+	oldConf := conf
+	defer func() { conf = oldConf }()
+	slim := func(name, family string) TableInfo {
+		return TableInfo{Name: name, Columns: []string{"ipfrom", "ipto", "ctry"}, Family: family}
+	}
+	tests := []struct {
+		name        string
+		connections int
+		tables      []TableInfo
+		want        string // Part of the expected error.  Empty when the tables are fine
+	}{
+		{"default pool size", 0, []TableInfo{slim("a", ""), slim("b", ""), slim("c", ""), slim("d", ""),
+			slim("e", ""), slim("f", "")}, ""},
+		{"IPv6 table", 0, []TableInfo{slim("slim_v6", "ipv6")}, ""},
+		{"pool too small", 2, []TableInfo{slim("a", ""), slim("b", "")}, "num_connections needs to be at least 3"},
+		{"unknown family", 0, []TableInfo{slim("a", "ipv5")}, "Unknown family 'ipv5'"},
+	}
+	for _, tt := range tests {
+		conf = Config{}
+		conf.Pg.NumConnections, conf.Tables = tt.connections, tt.tables
+		err := validateOutputTables(tt.tables)
+		switch {
+		case tt.want == "" && err != nil:
+			t.Errorf("%s: validateOutputTables() = %v", tt.name, err)
+		case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+			t.Errorf("%s: validateOutputTables() = %v, want %q", tt.name, err, tt.want)
+		}
+	}
+
+	// The IPv6 output tables hold the wider addresses
+	if got := columnType(familyIPv6, "ipto"); got != "numeric(39,0)" {
+		t.Errorf("columnType() of an IPv6 ipto column = %q", got)
+	}
+	row := oneRow{ipFrom: ipNum{hi: 1}}
+	if got, ok := columnValue(familyIPv6, row, "ipfrom").(*pgtype.Numeric); !ok ||
+		got.Int.String() != "18446744073709551616" {
+		t.Errorf("columnValue() of an IPv6 ipfrom = %v", got)
+	}
+}
+
+func TestWarningsAreCapped(t *testing.T) {
+	withTestSecrets(t, slog.LevelError)
+	warnings, warningCount = nil, 0
+	for i := 0; i < maxStoredWarnings+50; i++ {
+		warn("Problem %d\n", i)
+	}
+	if len(warnings) != maxStoredWarnings || warningCount != maxStoredWarnings+50 {
+		t.Errorf("after %d warnings, %d were kept and %d counted", maxStoredWarnings+50, len(warnings), warningCount)
+	}
+}
@@ -0,0 +1,267 @@
+// Package importer loads Geo-IP country data (from a Geo-IP.sqlite file, IP2Location, GeoLite2, or the RIR
+// delegation files) into country lookup tables in PostgreSQL, MySQL, or SQLite.
+//
+// It's the library behind the ip_country_code_lookup_importer command, for running the import from other Go
+// programs:
+//
+//	cfg, err := importer.LoadConfig("/etc/geoip.toml", "")
+//	if err != nil {
+//		return err
+//	}
+//	cfg.Options.Force = true
+//	result, err := importer.New(cfg).Run(ctx)
+//
+// The importer keeps the state of the running import in the package, so only one importer method runs at a time.
+// A call made while another is running (including a Daemon, or a "serve" subcommand, which run until their context
+// is cancelled) doesn't wait for it, and returns ErrBusy instead.
+package importer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// How to run an import.  The zero value turns off all of the optional checks, and creates no indexes other than
+// the primary key, so start from DefaultOptions to get the same behaviour as the command line tool
+type Options struct {
+	AssertIndexUsed   bool          // After importing, fail if the lookup query doesn't use an index
+	ChecksumVerify    bool          // After importing, fail if per column checksums of the data don't match the source
+	CombinedTable     bool          // Keep the IPv4 and IPv6 data in one table, with a family column, instead of two
+	CountryNameCase   string        // With FixCountryCase, either "keep" (the default) or "title"
+	FastVerify        bool          // Verify the row count using the planner's (approximate) estimate instead of count(*)
+	FixCountryCase    bool          // Upper case the 2 and 3 letter country codes
+	Force             bool          // When the Geo-IP source has a URL, download and import it even if it hasn't changed
+	HeartbeatInterval time.Duration // Log a heartbeat and check the PG connection this often during the import
+	Indexes           [][]string    // Indexes to create, each a list of columns.  See ParseIndexSpecs
+	Logger            *slog.Logger  // Where the status messages go.  Defaults to stderr, following the [log] settings
+	MaxRangeSize      int           // Warn about ranges covering more than this many addresses.  Defaults to per registry limits
+	MinFreeDiskMB     int           // MB of disk space which needs to remain free after extracting the Geo-IP file
+	ProgressEvery     int           // Log progress every this many rows imported.  Zero turns it off
+	RollbackOnWarning bool          // Roll back the import if any warnings are raised
+	SampleCheck       int           // After importing, fail if any of this many random rows don't match the source
+	ShuffleCheck      int           // After importing, check this many random addresses resolve the same in the source and PG
+	TableComment      string        // Comment to place on the country lookup table.  Defaults to the source and import time
+	TableName         string        // Name of the IPv4 lookup table, with _v6 appended for the IPv6 one
+	Upsert            bool          // Merge the new data into the existing tables, instead of dropping and recreating them
+	WebhookSlack      bool          // Send the webhook summary as a Slack compatible message
+	WebhookURL        string        // POST a JSON summary of the import to this URL when it finishes
+}
+
+// Returns the defaults of the command line tool's options
+func DefaultOptions() Options {
+	return Options{
+		CountryNameCase: "keep",
+		Indexes:         [][]string{{"ipto"}},
+		MinFreeDiskMB:   100,
+		ProgressEvery:   100000,
+	}
+}
+
+// An import, created with New.  Each Run downloads (when the Geo-IP source has a URL) and imports the data with
+// the same settings, so one importer can be run several times, as the daemon does
+type Importer struct {
+	cfg Config
+}
+
+// Serialises the importer methods, as they share the package's state
+var runMutex sync.Mutex
+
+// Returned by the importer methods when another one is already running in this process
+var ErrBusy = errors.New("Another import is already running in this process")
+
+// Creates an importer with the given settings.  They're checked when it's run
+func New(cfg Config) *Importer {
+	return &Importer{cfg: cfg}
+}
+
+// Downloads the Geo-IP source if it has a URL, then imports it, and lets the webhook and metrics servers know how
+// it went.  When the downloaded source is the same as last time the import is skipped, and the result has Skipped
+// set.  Cancelling ctx stops the import at the next row or database statement, and rolls it back
+func (i *Importer) Run(ctx context.Context) (result Result, err error) {
+	done, err := i.start(ctx, true)
+	if err != nil {
+		return
+	}
+	defer done()
+	return runCycle()
+}
+
+// Reads and checks the Geo-IP source, then prints (to stdout) the DDL the import would run and the number of rows
+// each table would get, without connecting to PostgreSQL
+func (i *Importer) DryRun(ctx context.Context) (err error) {
+	done, err := i.start(ctx, false)
+	if err != nil {
+		return
+	}
+	defer done()
+	return runDryRun()
+}
+
+// Runs the download and import on the schedule given in the [schedule] settings, until ctx is cancelled.  A
+// failed refresh is logged, and the daemon carries on with the next one.  Other importer calls return ErrBusy
+// while it's running
+func (i *Importer) Daemon(ctx context.Context) (err error) {
+	done, err := i.start(ctx, true)
+	if err != nil {
+		return
+	}
+	defer done()
+	return runDaemon()
+}
+
+// Runs one of the command line tool's subcommands ("assert-index-used", "lookup", "serve", "bench-lookup",
+// "source-sample", or "cleanup"), given its name followed by its arguments.  The output goes to stdout.  As "serve" runs until
+// ctx is cancelled, other importer calls return ErrBusy while it's serving
+func (i *Importer) RunCommand(ctx context.Context, args []string) (err error) {
+	if len(args) == 0 {
+		return fmt.Errorf("No subcommand given")
+	}
+	done, err := i.start(ctx, true)
+	if err != nil {
+		return
+	}
+	defer done()
+	switch args[0] {
+	case "assert-index-used":
+		return assertIndexUsedCmd()
+	case "lookup":
+		return lookup(args[1:])
+	case "serve":
+		return serve(args[1:])
+	case "bench-lookup":
+		return benchLookup(args[1:])
+	case "source-sample":
+		return sourceSample(args[1:])
+	case "cleanup":
+		return cleanup(args[1:])
+	}
+	return fmt.Errorf("Unknown subcommand '%s'", args[0])
+}
+
+// Masks the importer's secrets (the PostgreSQL and target passwords, the Geo-IP license key, and the S3 secret
+// key) in a message, as is done for its own log output
+func (i *Importer) Redact(msg string) string {
+	return redactSecrets(msg, configSecrets(i.cfg)...)
+}
+
+// Sets up the package state for the importer's settings, and checks them.  The TLS settings are loaded too when
+// loadTLS is set, so a bad CA certificate is reported before doing anything else.  The returned function releases
+// the state for the next call.  Returns ErrBusy if another call is still using it
+func (i *Importer) start(ctx context.Context, loadTLS bool) (done func(), err error) {
+	if !runMutex.TryLock() {
+		return nil, ErrBusy
+	}
+	defer func() {
+		if err != nil {
+			runMutex.Unlock()
+		}
+	}()
+
+	conf = i.cfg
+	o := i.cfg.Options
+	switch {
+	case o.Logger != nil:
+		logger = slog.New(redactingHandler{o.Logger.Handler()})
+	default:
+		level := conf.Log.Level
+		if level == "" {
+			level = "info"
+		}
+		err = setupLogging(level, conf.Log.Format)
+		if err != nil {
+			return
+		}
+	}
+	if o.CountryNameCase == "" {
+		o.CountryNameCase = "keep"
+	}
+	if o.CountryNameCase != "keep" && o.CountryNameCase != "title" {
+		err = fmt.Errorf("Unknown country name case '%s'.  Needs to be either \"keep\" or \"title\"",
+			o.CountryNameCase)
+		return
+	}
+	tableName := defaultTableName
+	if o.TableName != "" {
+		if !tableNameRegex.MatchString(o.TableName) {
+			err = fmt.Errorf("Invalid table name '%s'", o.TableName)
+			return
+		}
+		tableName = o.TableName
+	}
+
+	assertIndex, checksumCheck, countryNameCase = o.AssertIndexUsed, o.ChecksumVerify, o.CountryNameCase
+	fastVerify, fixCountryCase, forceImport = o.FastVerify, o.FixCountryCase, o.Force
+	heartbeatInterval, indexSpecs, maxRangeSize = o.HeartbeatInterval, o.Indexes, o.MaxRangeSize
+	minFreeDiskMB, progressEvery, rollbackOnWarning = o.MinFreeDiskMB, o.ProgressEvery, o.RollbackOnWarning
+	sampleCheckCount, shuffleCheckCount, tableComment = o.SampleCheck, o.ShuffleCheck, o.TableComment
+	upsert, webhookSlack, webhookURL, combinedTable = o.Upsert, o.WebhookSlack, o.WebhookURL, o.CombinedTable
+	setTableName(tableName)
+	runCtx = ctx
+
+	// Nothing is left over from the previous call
+	warnings, warningCount, ranges, sdb = nil, 0, nil, nil
+	atomic.StoreInt64(&rowsImported, 0)
+
+	if loadTLS {
+		pgTLS, err = loadTLSConfig()
+		if err != nil {
+			return
+		}
+	}
+	return func() {
+		runCtx, combinedTable = context.Background(), false
+		setTableName(defaultTableName)
+		runMutex.Unlock()
+	}, nil
+}
+
+// Downloads the Geo-IP source if it has a URL, then imports it and lets the webhook know how it went.  If the
+// downloaded source is the same as last time, the import is skipped
+func runCycle() (result Result, err error) {
+	// Start each run afresh, as the daemon runs several of them
+	warnings, warningCount = nil, 0
+	atomic.StoreInt64(&rowsImported, 0)
+	defer func(comment string) { tableComment = comment }(tableComment)
+
+	// Download the Geo-IP source if it has a URL, skipping the import when it's the same as last time.  A failed
+	// download is reported to the webhook and metrics servers the same as a failed import
+	result = Result{Source: conf.Geo.Path, StartTime: time.Now()}
+	var download downloadState
+	if conf.Geo.URL != "" {
+		var changed bool
+		changed, download, err = downloadSource(forceImport)
+		if err == nil && !changed {
+			return Result{Source: conf.Geo.Path, Skipped: true}, nil
+		}
+	}
+
+	// Run the import
+	if err == nil {
+		err = runImport(&result)
+	}
+	if err == nil && conf.Geo.URL != "" {
+		// Only remember the download once it's been imported, so a failed import is retried next time
+		if stateErr := saveDownloadState(download); stateErr != nil {
+			logger.Warn("Couldn't save the Geo-IP download state, so the next run will import it again", "err",
+				stateErr)
+		}
+	}
+	result.Duration = time.Since(result.StartTime).Seconds()
+	result.Success = err == nil
+	result.Warnings, result.WarningCount = warnings, warningCount
+	if err != nil {
+		result.Error = redactPassword(err.Error())
+	}
+
+	// Let the webhook and metrics servers know how the import went, if they were given
+	if webhookURL != "" {
+		postWebhook(webhookURL, result)
+	}
+	pushMetrics(result)
+	return
+}
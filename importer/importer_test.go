@@ -0,0 +1,245 @@
+package importer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// A small delegated-extended statistics file, so a source can be read without SQLite
+const testRIRData = `2|apnic|20240101|3|19830613|20240101|+1000
+apnic|*|ipv4|*|2|summary
+apnic|AU|ipv4|1.0.0.0|256|20110811|assigned
+apnic|JP|ipv4|1.0.16.0|4096|20110412|allocated
+apnic|CN|ipv4|1.0.32.0|8192|20110412|reserved
+`
+
+// Returns settings for reading the test RIR file, with the logging discarded
+func testConfig(t *testing.T) Config {
+	path := filepath.Join(t.TempDir(), "delegated-apnic-extended-latest")
+	if err := os.WriteFile(path, []byte(testRIRData), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := Config{Options: DefaultOptions()}
+	cfg.Geo.Format, cfg.Geo.Path = formatRIR, path
+	cfg.Options.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	return cfg
+}
+
+// Runs fn, returning what it wrote to stdout
+func captureStdout(t *testing.T, fn func() error) (string, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldStdout := os.Stdout
+	os.Stdout = w
+	out := make(chan string)
+	go func() {
+		b, _ := io.ReadAll(r)
+		out <- string(b)
+	}()
+	err = fn()
+	os.Stdout = oldStdout
+	w.Close()
+	return <-out, err
+}
+
+func TestDryRun(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.Options.TableName = "geo"
+	out, err := captureStdout(t, func() error { return New(cfg).DryRun(context.Background()) })
+	if err != nil {
+		t.Fatalf("DryRun() failed: %v", err)
+	}
+	for _, want := range []string{
+		"The Geo-IP source has no IPv6 data, so geo_v6 would be left alone",
+		"DROP TABLE IF EXISTS geo_new;",
+		"CREATE TABLE geo_new",
+		"COMMENT ON TABLE geo_new IS",
+		"CREATE INDEX geo_new_ipto_index ON geo_new",
+		"ALTER TABLE geo_new RENAME TO geo;",
+		"ALTER INDEX geo_new_pk RENAME TO geo_pk;",
+		"ALTER INDEX geo_new_ipto_index RENAME TO geo_ipto_index;",
+		"geo: 2\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("DryRun() output doesn't include %q:\n%s", want, out)
+		}
+	}
+
+	// The table name only applies while the importer is running
+	if familyIPv4.pgTable != defaultTableName {
+		t.Errorf("the IPv4 table is still named %q after the dry run", familyIPv4.pgTable)
+	}
+}
+
+func TestDryRunCombinedTable(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.Options.TableName, cfg.Options.CombinedTable = "geo", true
+	out, err := captureStdout(t, func() error { return New(cfg).DryRun(context.Background()) })
+	if err != nil {
+		t.Fatalf("DryRun() failed: %v", err)
+	}
+	for _, want := range []string{
+		"The Geo-IP source has no IPv6 data, so geo would have none",
+		"family smallint not null",
+		"ipfrom numeric(39,0)",
+		"constraint geo_new_pk primary key (family, ipfrom)",
+		"COMMENT ON COLUMN geo_new.family IS",
+		"CREATE INDEX geo_new_ipto_index ON geo_new (family, ipto);",
+		"ALTER TABLE geo_new RENAME TO geo;",
+		"geo (IPv4): 2\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("DryRun() output doesn't include %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "geo_v6") {
+		t.Errorf("DryRun() with a combined table mentions the IPv6 table:\n%s", out)
+	}
+
+	// Lookups only look at the address family's rows
+	done, err := New(cfg).start(context.Background(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := lookupCondition(familyIPv6)
+	done()
+	if got != "family = 6 AND $1 BETWEEN ipfrom AND ipto" {
+		t.Errorf("lookupCondition() for a combined table = %q", got)
+	}
+	if familyIPv6.pgTable != defaultTableName+"_v6" {
+		t.Errorf("the IPv6 table is still named %q after the combined table run", familyIPv6.pgTable)
+	}
+}
+
+func TestRunRejectsInvalidOptions(t *testing.T) {
+	tests := []struct {
+		name string
+		set  func(cfg *Config)
+		want string
+	}{
+		{"country name case", func(cfg *Config) { cfg.Options.CountryNameCase = "upper" }, "Unknown country name case"},
+		{"table name", func(cfg *Config) { cfg.Options.TableName = "geo; DROP TABLE x" }, "Invalid table name"},
+		{"log level", func(cfg *Config) { cfg.Options.Logger, cfg.Log.Level = nil, "loud" }, "Unknown log level"},
+		{"combined upsert", func(cfg *Config) { cfg.Options.CombinedTable, cfg.Options.Upsert = true, true },
+			"-combined-table can't be used with -upsert"},
+	}
+	for _, tt := range tests {
+		cfg := testConfig(t)
+		tt.set(&cfg)
+		_, err := New(cfg).Run(context.Background())
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: Run() error = %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+// Stands in for a database, accepting whatever rows it's sent
+type discardWriter struct {
+	writer
+}
+
+func (discardWriter) BulkInsert(fam ipFamily, rows <-chan oneRow) (n int64, err error) {
+	for range rows {
+		n++
+	}
+	return
+}
+
+func TestImportFamilyCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done, err := New(testConfig(t)).start(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer done()
+	closeSource, err := openSource()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeSource()
+
+	cancel()
+	err = importFamily(discardWriter{}, familyIPv4, nil)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("importFamily() with a cancelled context = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestImporterRedact(t *testing.T) {
+	var cfg Config
+	cfg.Pg.Password, cfg.Geo.LicenseKey = testPassword, "licence-key-1234"
+	got := New(cfg).Redact("failed with " + testPassword + " and licence-key-1234")
+	if got != "failed with **** and ****" {
+		t.Errorf("Redact() = %q", got)
+	}
+}
+
+func TestImporterBusy(t *testing.T) {
+	done, err := New(testConfig(t)).start(context.Background(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = New(testConfig(t)).DryRun(context.Background())
+	done()
+	if !errors.Is(err, ErrBusy) {
+		t.Errorf("DryRun() while another call is running = %v, want %v", err, ErrBusy)
+	}
+}
+
+func TestStartResetsRunState(t *testing.T) {
+	warnings = []string{"left over from an earlier run"}
+	done, err := New(testConfig(t)).start(context.Background(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer done()
+	if len(warnings) != 0 || ranges != nil || sdb != nil {
+		t.Errorf("start() kept the previous run's state: warnings %q, ranges %v", warnings, ranges)
+	}
+
+	// Closing the source forgets it, so a later call can't read it by mistake
+	closeSource, err := openSource()
+	if err != nil {
+		t.Fatal(err)
+	}
+	closeSource()
+	if ranges != nil {
+		t.Error("the Geo-IP source is still set after closing it")
+	}
+}
+
+func TestRunReportsFailedDownload(t *testing.T) {
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "gone", http.StatusNotFound)
+	}))
+	defer source.Close()
+	var sent Result
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&sent)
+	}))
+	defer webhook.Close()
+
+	cfg := testConfig(t)
+	cfg.Geo.URL, cfg.Options.WebhookURL = source.URL, webhook.URL
+	result, err := New(cfg).Run(context.Background())
+	if err == nil {
+		t.Fatal("Run() with a failing download didn't return an error")
+	}
+	if result.Success || result.Error == "" {
+		t.Errorf("Run() result = %+v, want a failure", result)
+	}
+	if sent.Success || !strings.Contains(sent.Error, "404") {
+		t.Errorf("webhook was sent %+v, want the download failure", sent)
+	}
+}
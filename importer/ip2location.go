@@ -0,0 +1,169 @@
+package importer
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+)
+
+// An IP2Location BIN database file, loaded into memory
+type ip2locationBIN struct {
+	data      []byte
+	dbType    byte
+	dbColumn  byte
+	year      byte
+	month     byte
+	day       byte
+	ipv4Count uint32
+	ipv4Addr  uint32
+	ipv6Count uint32
+	ipv6Addr  uint32
+}
+
+// Size of the BIN file header
+const ip2locationHeaderSize = 29
+
+// Loads an IP2Location BIN file, and reads its header
+func openIP2LocationBIN(path string) (bin *ip2locationBIN, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	if len(data) < ip2locationHeaderSize {
+		err = fmt.Errorf("'%s' is too small to be an IP2Location BIN file", path)
+		return
+	}
+	bin = &ip2locationBIN{
+		data:     data,
+		dbType:   data[0],
+		dbColumn: data[1],
+		year:     data[2],
+		month:    data[3],
+		day:      data[4],
+	}
+	bin.ipv4Count, _ = bin.readUint32(6)
+	bin.ipv4Addr, _ = bin.readUint32(10)
+	bin.ipv6Count, _ = bin.readUint32(14)
+	bin.ipv6Addr, _ = bin.readUint32(18)
+
+	// The country is stored in the second column, so any DB type holding fewer columns isn't usable
+	if bin.dbColumn < 2 {
+		err = fmt.Errorf("'%s' has %d columns per record, which doesn't include a country", path, bin.dbColumn)
+		return
+	}
+
+	// Make sure the records (plus the trailing record marking the end of the last range) fit in the file.  IPv4
+	// only BIN files have no IPv6 records
+	if bin.ipv4Addr == 0 || !bin.fits(bin.ipv4Addr, bin.ipv4Count, bin.recordSize(4)) ||
+		(bin.ipv6Count > 0 && !bin.fits(bin.ipv6Addr, bin.ipv6Count, bin.recordSize(16))) {
+		err = fmt.Errorf("'%s' is truncated or not an IP2Location BIN file", path)
+		return
+	}
+	return
+}
+
+// Returns the size of each record, for the given size of IP address.  The IP address is the first column, with
+// the other columns being 4 bytes each
+func (b *ip2locationBIN) recordSize(ipSize uint32) uint32 {
+	return ipSize + (uint32(b.dbColumn)-1)*4
+}
+
+// Returns true if the given number of records (plus the trailing one) starting at addr fit in the file
+func (b *ip2locationBIN) fits(addr, count, recSize uint32) bool {
+	return uint64(addr)-1+(uint64(count)+1)*uint64(recSize) <= uint64(len(b.data))
+}
+
+// Returns true if the BIN file has data for the address family.  IPv4 only files (eg DB1) have an IPv6 record count
+// of zero, and their IPv6 table is left alone rather than replaced with an empty one
+func (b *ip2locationBIN) hasFamily(fam ipFamily) bool {
+	return fam == familyIPv4 || b.ipv6Count > 0
+}
+
+// Reads each IPv4 record from the BIN file, passing them to the given function
+func (b *ip2locationBIN) readIPv4(fn func(row oneRow) error) error {
+	return b.readRecords(b.ipv4Addr, b.ipv4Count, 4, fn)
+}
+
+// Reads each IPv6 record from the BIN file, passing them to the given function
+func (b *ip2locationBIN) readIPv6(fn func(row oneRow) error) error {
+	if b.ipv6Count == 0 {
+		return nil
+	}
+	return b.readRecords(b.ipv6Addr, b.ipv6Count, 16, fn)
+}
+
+// Reads the records from one of the BIN file's address sections.  Ranges which aren't assigned to a country
+// (country code "-") are skipped, as they're not useful for lookups
+func (b *ip2locationBIN) readRecords(addr, count, ipSize uint32, fn func(row oneRow) error) (err error) {
+	recSize := b.recordSize(ipSize)
+	for i := uint32(0); i < count; i++ {
+		offset := addr + i*recSize
+
+		// Each range finishes immediately before the start of the next one
+		var ipFrom, ipTo ipNum
+		var ctryPos uint32
+		if ipFrom, err = b.readIP(offset, ipSize); err != nil {
+			return
+		}
+		if ipTo, err = b.readIP(offset+recSize, ipSize); err != nil {
+			return
+		}
+		if ipTo != (ipNum{}) {
+			ipTo = ipTo.sub(ipNumFromUint64(1))
+		}
+
+		// The country column points at the short country code, followed by the long country name
+		if ctryPos, err = b.readUint32(offset + ipSize); err != nil {
+			return
+		}
+		row := oneRow{ipFrom: ipFrom, ipTo: ipTo}
+		if row.ctry, err = b.readString(ctryPos); err != nil {
+			return
+		}
+		if row.country, err = b.readString(ctryPos + 3); err != nil {
+			return
+		}
+		if row.ctry == "-" {
+			continue
+		}
+		if err = fn(row); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Reads a 4 byte (IPv4) or 16 byte (IPv6) little endian IP address
+func (b *ip2locationBIN) readIP(pos, ipSize uint32) (ipNum, error) {
+	if ipSize == 4 {
+		v, err := b.readUint32(pos)
+		return ipNumFromUint64(uint64(v)), err
+	}
+	if pos == 0 || uint64(pos)+15 > uint64(len(b.data)) {
+		return ipNum{}, fmt.Errorf("IP2Location BIN file position %d is out of range", pos)
+	}
+	return ipNum{
+		lo: binary.LittleEndian.Uint64(b.data[pos-1:]),
+		hi: binary.LittleEndian.Uint64(b.data[pos+7:]),
+	}, nil
+}
+
+// Reads a little endian uint32.  Record positions in BIN files are 1-based
+func (b *ip2locationBIN) readUint32(pos uint32) (uint32, error) {
+	if pos == 0 || uint64(pos)+3 > uint64(len(b.data)) {
+		return 0, fmt.Errorf("IP2Location BIN file position %d is out of range", pos)
+	}
+	return binary.LittleEndian.Uint32(b.data[pos-1:]), nil
+}
+
+// Reads a length prefixed string.  Unlike record positions, string positions are 0-based
+func (b *ip2locationBIN) readString(pos uint32) (string, error) {
+	if uint64(pos) >= uint64(len(b.data)) {
+		return "", fmt.Errorf("IP2Location BIN file string position %d is out of range", pos)
+	}
+	end := uint64(pos) + 1 + uint64(b.data[pos])
+	if end > uint64(len(b.data)) {
+		return "", fmt.Errorf("IP2Location BIN file string at position %d is truncated", pos)
+	}
+	return string(b.data[pos+1 : end]), nil
+}
@@ -0,0 +1,125 @@
+package importer
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"math/bits"
+	"net"
+	"strconv"
+)
+
+// An IP address as an unsigned 128 bit integer, wide enough for IPv6.  IPv4 addresses only use the low 32 bits.
+// Unlike big.Int it's a plain value, so it can be compared with == and used as a map key
+type ipNum struct {
+	hi, lo uint64
+}
+
+// Returns the ipNum for an integer value
+func ipNumFromUint64(v uint64) ipNum {
+	return ipNum{lo: v}
+}
+
+// Returns the ipNum for an IP address.  IPv4 addresses (including IPv4 mapped IPv6 ones) give their 32 bit value
+func ipNumFromIP(ip net.IP) ipNum {
+	if v4 := ip.To4(); v4 != nil {
+		return ipNumFromUint64(uint64(binary.BigEndian.Uint32(v4)))
+	}
+	ip = ip.To16()
+	return ipNum{hi: binary.BigEndian.Uint64(ip[:8]), lo: binary.BigEndian.Uint64(ip[8:])}
+}
+
+// Returns the first and last addresses of a network in CIDR notation, eg "1.0.0.0/24" or "2001:200::/23"
+func parseCIDRRange(cidr string) (from, to ipNum, err error) {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return
+	}
+	ones, size := network.Mask.Size()
+	from = ipNumFromIP(network.IP)
+
+	// Set all of the host bits to get the last address
+	host := uint(size - ones)
+	to = from
+	switch {
+	case host >= 64:
+		to.lo = ^uint64(0)
+		if host > 64 {
+			to.hi |= ^uint64(0) >> (128 - host)
+		}
+	case host > 0:
+		to.lo |= ^uint64(0) >> (64 - host)
+	}
+	return
+}
+
+// Parses a decimal integer string, as used for the IPv6 ranges in the Geo-IP database
+func parseIPNum(s string) (n ipNum, err error) {
+	b, ok := new(big.Int).SetString(s, 10)
+	if !ok || b.Sign() < 0 || b.BitLen() > 128 {
+		err = fmt.Errorf("'%s' isn't a valid 128 bit unsigned integer", s)
+		return
+	}
+	return ipNumFromBig(b), nil
+}
+
+// Converts a big.Int holding a value between 0 and 2^128-1
+func ipNumFromBig(b *big.Int) ipNum {
+	lo := new(big.Int).And(b, new(big.Int).SetUint64(^uint64(0)))
+	hi := new(big.Int).Rsh(b, 64)
+	return ipNum{hi: hi.Uint64(), lo: lo.Uint64()}
+}
+
+// Returns the value as a big.Int
+func (n ipNum) big() *big.Int {
+	b := new(big.Int).SetUint64(n.hi)
+	b.Lsh(b, 64)
+	return b.Or(b, new(big.Int).SetUint64(n.lo))
+}
+
+// Compares two values, returning -1, 0, or +1
+func (n ipNum) cmp(m ipNum) int {
+	switch {
+	case n.hi < m.hi:
+		return -1
+	case n.hi > m.hi:
+		return 1
+	case n.lo < m.lo:
+		return -1
+	case n.lo > m.lo:
+		return 1
+	}
+	return 0
+}
+
+// Returns n - m.  The result wraps around if m is larger than n
+func (n ipNum) sub(m ipNum) ipNum {
+	lo, borrow := bits.Sub64(n.lo, m.lo, 0)
+	hi, _ := bits.Sub64(n.hi, m.hi, borrow)
+	return ipNum{hi: hi, lo: lo}
+}
+
+// Returns n + 1.  The result wraps around at 2^128
+func (n ipNum) inc() ipNum {
+	lo, carry := bits.Add64(n.lo, 1, 0)
+	return ipNum{hi: n.hi + carry, lo: lo}
+}
+
+// Returns the value in decimal
+func (n ipNum) String() string {
+	if n.hi == 0 {
+		return strconv.FormatUint(n.lo, 10)
+	}
+	return n.big().String()
+}
+
+// Formats the value as an IP address of the address family, eg "1.0.0.0" or "2001:200::"
+func formatIPNum(fam ipFamily, n ipNum) string {
+	if fam == familyIPv4 {
+		return intToIPv4(int(n.lo))
+	}
+	ip := make(net.IP, net.IPv6len)
+	binary.BigEndian.PutUint64(ip[:8], n.hi)
+	binary.BigEndian.PutUint64(ip[8:], n.lo)
+	return ip.String()
+}
@@ -0,0 +1,87 @@
+package importer
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/jackc/pgx/pgtype"
+)
+
+// The largest IPv6 address, 2^128 - 1
+var maxIPNum = ipNum{hi: ^uint64(0), lo: ^uint64(0)}
+
+func TestParseIPNum(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    ipNum
+		wantErr bool
+	}{
+		{"0", ipNum{}, false},
+		{"18446744073709551615", ipNum{lo: ^uint64(0)}, false},
+		{"18446744073709551616", ipNum{hi: 1}, false},
+		{"340282366920938463463374607431768211455", maxIPNum, false},
+		{"340282366920938463463374607431768211456", ipNum{}, true},
+		{"-1", ipNum{}, true},
+		{"1e5", ipNum{}, true},
+	}
+	for _, tt := range tests {
+		got, err := parseIPNum(tt.s)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseIPNum(%q) = %v, %v, want %v (error: %v)", tt.s, got, err, tt.want, tt.wantErr)
+		}
+		if err == nil && got.String() != tt.s {
+			t.Errorf("parseIPNum(%q).String() = %q", tt.s, got.String())
+		}
+	}
+}
+
+func TestIPNumArithmetic(t *testing.T) {
+	// Carrying and borrowing between the two halves
+	if got := (ipNum{lo: ^uint64(0)}).inc(); got != (ipNum{hi: 1}) {
+		t.Errorf("inc() across 2^64 = %v", got)
+	}
+	if got := (ipNum{hi: 1}).sub(ipNum{lo: 1}); got != (ipNum{lo: ^uint64(0)}) {
+		t.Errorf("sub() across 2^64 = %v", got)
+	}
+	if got := maxIPNum.inc(); got != (ipNum{}) {
+		t.Errorf("inc() of 2^128 - 1 = %v, want it to wrap to 0", got)
+	}
+
+	// The size of a /23, the way the range size checks work it out
+	from, to, err := parseCIDRRange("2001:200::/23")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := to.sub(from).inc(); got != (ipNum{hi: 1 << 41}) {
+		t.Errorf("size of a /23 = %v, want 2^105", got)
+	}
+	if from.cmp(to) != -1 || to.cmp(from) != 1 || to.cmp(to) != 0 {
+		t.Errorf("cmp() of %v and %v is inconsistent", from, to)
+	}
+	if got := ipNumFromBig(maxIPNum.big()); got != maxIPNum {
+		t.Errorf("ipNumFromBig(big()) of 2^128 - 1 = %v", got)
+	}
+}
+
+func TestCopyArgEncodesIPv6AsNumeric(t *testing.T) {
+	// COPY sends the values in binary, so check they decode back to the same numbers
+	ci := pgtype.NewConnInfo()
+	for _, n := range []ipNum{{}, {hi: 1}, maxIPNum} {
+		arg, ok := familyIPv6.copyArg(n).(pgtype.BinaryEncoder)
+		if !ok {
+			t.Fatalf("copyArg(%v) = %T, which can't be sent in binary", n, familyIPv6.copyArg(n))
+		}
+		buf, err := arg.EncodeBinary(ci, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got pgtype.Numeric
+		if err = got.DecodeBinary(ci, buf); err != nil {
+			t.Fatal(err)
+		}
+		scale := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(got.Exp)), nil)
+		if v := new(big.Int).Mul(got.Int, scale); v.String() != n.String() {
+			t.Errorf("copyArg(%v) decodes as %s", n, v)
+		}
+	}
+}
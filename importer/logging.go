@@ -0,0 +1,76 @@
+package importer
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+// Leveled logger for the status messages during the import, such as each step, warnings, progress, and the daemon's
+// refreshes.  Set up from the settings each time an importer starts running.  Only the command output (the output
+// of -dry-run and the subcommands) goes to stdout, with the binary printing the import summary
+var logger = slog.New(redactingHandler{slog.NewTextHandler(os.Stderr, nil)})
+
+// Wraps a log handler, masking the secrets in each message and its attributes (such as errors) with
+// redactPassword
+type redactingHandler struct {
+	slog.Handler
+}
+
+func (h redactingHandler) Handle(ctx context.Context, r slog.Record) error {
+	out := slog.NewRecord(r.Time, r.Level, redactPassword(r.Message), r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		out.AddAttrs(redactAttr(a))
+		return true
+	})
+	return h.Handler.Handle(ctx, out)
+}
+
+func (h redactingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	for i, a := range attrs {
+		attrs[i] = redactAttr(a)
+	}
+	return redactingHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h redactingHandler) WithGroup(name string) slog.Handler {
+	return redactingHandler{h.Handler.WithGroup(name)}
+}
+
+// Masks the secrets in a log attribute.  Anything other than numbers, times and the like is logged as its string form
+func redactAttr(a slog.Attr) slog.Attr {
+	v := a.Value.Resolve()
+	switch v.Kind() {
+	case slog.KindString, slog.KindAny:
+		return slog.String(a.Key, redactPassword(v.String()))
+	case slog.KindGroup:
+		attrs := v.Group()
+		redacted := make([]any, len(attrs))
+		for i, ga := range attrs {
+			redacted[i] = redactAttr(ga)
+		}
+		return slog.Group(a.Key, redacted...)
+	}
+	return slog.Attr{Key: a.Key, Value: v}
+}
+
+// Sets up the logger with the given level ("debug", "info", "warn", or "error") and format ("text" or "json")
+func setupLogging(level, format string) (err error) {
+	var lvl slog.Level
+	err = lvl.UnmarshalText([]byte(level))
+	if err != nil {
+		return fmt.Errorf("Unknown log level '%s'.  Needs to be one of \"debug\", \"info\", \"warn\", or \"error\"",
+			level)
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+	switch format {
+	case "", "text":
+		logger = slog.New(redactingHandler{slog.NewTextHandler(os.Stderr, opts)})
+	case "json":
+		logger = slog.New(redactingHandler{slog.NewJSONHandler(os.Stderr, opts)})
+	default:
+		return fmt.Errorf("Unknown log format '%s'.  Needs to be either \"text\" or \"json\"", format)
+	}
+	return
+}
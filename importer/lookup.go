@@ -0,0 +1,240 @@
+package importer
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/jackc/pgx"
+)
+
+// Returned by lookupAddress when the address isn't in any of the imported ranges
+var errNoCountry = errors.New("no country found")
+
+// The country an IP address maps to in the imported data
+type lookupResult struct {
+	IP      string `json:"ip"`
+	Ctry    string `json:"ctry"`
+	Cntry   string `json:"cntry"`
+	Country string `json:"country"`
+}
+
+// Parses an IP address, returning its address family and numeric value
+func parseLookupAddress(addr string) (fam ipFamily, n ipNum, err error) {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return fam, n, fmt.Errorf("'%s' isn't a valid IP address", addr)
+	}
+	fam, n = familyIPv6, ipNumFromIP(ip)
+	if ip.To4() != nil {
+		fam = familyIPv4
+	}
+	return
+}
+
+// Finds the range holding an IP address in the imported table for its address family
+func lookupAddress(q queryer, addr string, fam ipFamily, n ipNum) (result lookupResult, err error) {
+	result.IP = addr
+	dbQuery := fmt.Sprintf(`
+		SELECT ctry, coalesce(cntry, ''), coalesce(country, '')
+		FROM %s
+		WHERE %s`, fam.pgTable, lookupCondition(fam))
+	err = q.QueryRow(dbQuery, fam.keyArg(n)).Scan(&result.Ctry, &result.Cntry, &result.Country)
+	if err == pgx.ErrNoRows {
+		err = errNoCountry
+	}
+	return
+}
+
+// The fields of a lookup result which can be printed, in their default order, and the formats they can be printed in
+var (
+	lookupFields  = []string{"ip", "ctry", "cntry", "country"}
+	lookupFormats = []string{"text", "json", "csv"}
+)
+
+// Returns the value of one of the lookupFields
+func (r lookupResult) field(name string) string {
+	switch name {
+	case "ip":
+		return r.IP
+	case "ctry":
+		return r.Ctry
+	case "cntry":
+		return r.Cntry
+	}
+	return r.Country
+}
+
+// Parses a comma separated list of lookup result fields, in any case.  An empty list gives all of them
+func parseLookupFields(s string) (fields []string, err error) {
+	if s == "" {
+		return lookupFields, nil
+	}
+	for _, f := range strings.Split(s, ",") {
+		f = strings.ToLower(strings.TrimSpace(f))
+		if !slices.Contains(lookupFields, f) {
+			return nil, fmt.Errorf("Unknown lookup field '%s'.  Needs to be one of %s", f,
+				strings.Join(lookupFields, ", "))
+		}
+		fields = append(fields, f)
+	}
+	return
+}
+
+// Writes a lookup result in the given format ("text", "json", or "csv"), with just the given fields.  The text
+// format with all of the fields is a human readable line, and otherwise it's their values separated by spaces.
+// The CSV has a header line
+func writeLookupResult(w io.Writer, result lookupResult, format string, fields []string) (err error) {
+	values := make([]string, len(fields))
+	for i, f := range fields {
+		values[i] = result.field(f)
+	}
+	switch format {
+	case "text":
+		if slices.Equal(fields, lookupFields) {
+			_, err = fmt.Fprintf(w, "%s: %s (%s, %s)\n", result.IP, result.Ctry, result.Cntry, result.Country)
+			return
+		}
+		_, err = fmt.Fprintln(w, strings.Join(values, " "))
+	case "json":
+		obj := make(map[string]string, len(fields))
+		for i, f := range fields {
+			obj[f] = values[i]
+		}
+		err = json.NewEncoder(w).Encode(obj)
+	case "csv":
+		c := csv.NewWriter(w)
+		c.Write(fields)
+		c.Write(values)
+		c.Flush()
+		err = c.Error()
+	default:
+		err = unknownLookupFormat(format)
+	}
+	return
+}
+
+// Returns the error for a lookup output format which isn't one of lookupFormats
+func unknownLookupFormat(format string) error {
+	return fmt.Errorf("Unknown lookup format '%s'.  Needs to be either \"text\", \"json\", or \"csv\"", format)
+}
+
+// Looks up the country of a single IP address in the imported data, and prints it
+func lookup(args []string) (err error) {
+	fs := flag.NewFlagSet("lookup", flag.ContinueOnError)
+	format := fs.String("format", "text", "Output format.  Either \"text\", \"json\", or \"csv\"")
+	fieldList := fs.String("fields", "", "Comma separated list of the fields to print, from ip, ctry, cntry, and "+
+		"country (default all of them)")
+	err = fs.Parse(args)
+	if err != nil {
+		return
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: lookup [-format text|json|csv] [-fields <list>] <ip address>")
+	}
+	addr := fs.Arg(0)
+
+	// Check the address and options are valid before connecting
+	fam, n, err := parseLookupAddress(addr)
+	if err != nil {
+		return
+	}
+	fields, err := parseLookupFields(*fieldList)
+	if err != nil {
+		return
+	}
+	*format = strings.ToLower(*format)
+	if !slices.Contains(lookupFormats, *format) {
+		return unknownLookupFormat(*format)
+	}
+
+	// Connect to PG
+	err = connectPG()
+	if err != nil {
+		return
+	}
+	defer pg.Close()
+
+	result, err := lookupAddress(pg, addr, fam, n)
+	if err == errNoCountry {
+		return fmt.Errorf("No country found for %s", addr)
+	}
+	if err != nil {
+		return
+	}
+	return writeLookupResult(os.Stdout, result, *format, fields)
+}
+
+// Serves lookups of the imported data over HTTP, as JSON from GET /lookup?ip=<address>.  Runs until the context
+// is cancelled
+func serve(args []string) (err error) {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	listen := fs.String("listen", ":8080", "Address to serve the lookup endpoint on")
+	err = fs.Parse(args)
+	if err != nil {
+		return
+	}
+
+	err = connectPG()
+	if err != nil {
+		return
+	}
+	defer pg.Close()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/lookup", serveLookup)
+	srv := &http.Server{Addr: *listen, Handler: mux}
+	stop := context.AfterFunc(runCtx, func() { srv.Close() })
+	defer stop()
+	logger.Info("Serving the lookup endpoint", "listen", *listen)
+	err = srv.ListenAndServe()
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return
+}
+
+// Handles a lookup request.  Invalid addresses get a 400, and addresses which aren't in any range get a 404
+func serveLookup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "Only GET is supported", http.StatusMethodNotAllowed)
+		return
+	}
+	addr := r.URL.Query().Get("ip")
+	fam, n, err := parseLookupAddress(addr)
+	if err != nil {
+		writeLookupError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	result, err := lookupAddress(pg, addr, fam, n)
+	if err == errNoCountry {
+		writeLookupError(w, http.StatusNotFound, fmt.Sprintf("No country found for %s", addr))
+		return
+	}
+	if err != nil {
+		logger.Error("Lookup failed", "ip", addr, "err", redactPassword(err.Error()))
+		writeLookupError(w, http.StatusInternalServerError, "Lookup failed")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// Sends a JSON error response from the lookup endpoint
+func writeLookupError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(struct {
+		Error string `json:"error"`
+	}{msg})
+}
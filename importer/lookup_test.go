@@ -0,0 +1,55 @@
+package importer
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestWriteLookupResult(t *testing.T) {
+	result := lookupResult{IP: "1.0.0.1", Ctry: "AU", Cntry: "AUS", Country: "Australia, Commonwealth of"}
+	tests := []struct {
+		format string
+		fields string
+		want   string
+	}{
+		{"text", "", "1.0.0.1: AU (AUS, Australia, Commonwealth of)\n"},
+		{"text", "ctry", "AU\n"},
+		{"text", "IP, Ctry", "1.0.0.1 AU\n"},
+		{"json", "", `{"cntry":"AUS","country":"Australia, Commonwealth of","ctry":"AU","ip":"1.0.0.1"}` + "\n"},
+		{"json", "ctry", `{"ctry":"AU"}` + "\n"},
+		{"csv", "", "ip,ctry,cntry,country\n1.0.0.1,AU,AUS,\"Australia, Commonwealth of\"\n"},
+		{"csv", "country,ip", "country,ip\n\"Australia, Commonwealth of\",1.0.0.1\n"},
+	}
+	for _, tt := range tests {
+		fields, err := parseLookupFields(tt.fields)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var b bytes.Buffer
+		if err = writeLookupResult(&b, result, tt.format, fields); err != nil {
+			t.Fatal(err)
+		}
+		if b.String() != tt.want {
+			t.Errorf("writeLookupResult(%s, %q) = %q, want %q", tt.format, tt.fields, b.String(), tt.want)
+		}
+	}
+}
+
+func TestLookupRejectsInvalidOptions(t *testing.T) {
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"lookup", "-fields", "ctry,continent", "1.0.0.1"}, "Unknown lookup field 'continent'"},
+		{[]string{"lookup", "-format", "xml", "1.0.0.1"}, "Unknown lookup format 'xml'"},
+		{[]string{"lookup", "-format", "json"}, "usage: lookup"},
+	}
+	for _, tt := range tests {
+		err := New(testConfig(t)).RunCommand(context.Background(), tt.args)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("RunCommand(%q) = %v, want %q", tt.args, err, tt.want)
+		}
+	}
+}
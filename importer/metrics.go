@@ -0,0 +1,105 @@
+package importer
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Default Pushgateway job name and statsd metric prefix
+const defaultMetricsName = "ip_country_code_lookup_importer"
+
+// A metric describing an import run
+type runMetric struct {
+	name  string
+	help  string
+	value float64
+}
+
+// Formats the metric's value in plain decimal, as statsd doesn't accept exponents
+func (m runMetric) formatValue() string {
+	return strconv.FormatFloat(m.value, 'f', -1, 64)
+}
+
+// Returns the metrics for an import run
+func summaryMetrics(summary Result) []runMetric {
+	success := 0.0
+	if summary.Success {
+		success = 1
+	}
+	return []runMetric{
+		{"duration_seconds", "How long the import took", summary.Duration},
+		{"ipv4_rows", "Number of IPv4 rows imported", float64(summary.IPv4Rows)},
+		{"ipv6_rows", "Number of IPv6 rows imported", float64(summary.IPv6Rows)},
+		{"warnings", "Number of warnings raised during the import", float64(summary.WarningCount)},
+		{"success", "Whether the import succeeded (1) or failed (0)", success},
+		{"last_run_timestamp_seconds", "When the import started, as a Unix timestamp", float64(summary.StartTime.Unix())},
+	}
+}
+
+// Sends the metrics for an import run to the Pushgateway and statsd server in the config file, if they're set.
+// Failures are only logged, as they shouldn't fail the import
+func pushMetrics(summary Result) {
+	metrics := summaryMetrics(summary)
+	if conf.Metrics.Pushgateway != "" {
+		if err := pushGateway(conf.Metrics.Pushgateway, metrics); err != nil {
+			logger.Warn("Pushing the metrics to the Pushgateway failed", "err", err)
+		}
+	}
+	if conf.Metrics.Statsd != "" {
+		if err := sendStatsd(conf.Metrics.Statsd, metrics); err != nil {
+			logger.Warn("Sending the metrics to statsd failed", "err", err)
+		}
+	}
+}
+
+// Replaces the import job's metrics on a Prometheus Pushgateway, in the text exposition format
+func pushGateway(server string, metrics []runMetric) (err error) {
+	job := conf.Metrics.Job
+	if job == "" {
+		job = defaultMetricsName
+	}
+	var body bytes.Buffer
+	for _, m := range metrics {
+		name := defaultMetricsName + "_" + m.name
+		fmt.Fprintf(&body, "# HELP %s %s\n# TYPE %s gauge\n%s %s\n", name, m.help, name, name, m.formatValue())
+	}
+	req, err := http.NewRequest(http.MethodPut, server+"/metrics/job/"+url.PathEscape(job), &body)
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+	client := http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	return
+}
+
+// Sends the metrics to a statsd server as gauges, in one UDP packet
+func sendStatsd(server string, metrics []runMetric) (err error) {
+	prefix := conf.Metrics.Prefix
+	if prefix == "" {
+		prefix = defaultMetricsName
+	}
+	var body bytes.Buffer
+	for _, m := range metrics {
+		fmt.Fprintf(&body, "%s.%s:%s|g\n", prefix, m.name, m.formatValue())
+	}
+	conn, err := net.DialTimeout("udp", server, 5*time.Second)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	_, err = conn.Write(body.Bytes())
+	return
+}
@@ -0,0 +1,201 @@
+package importer
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// Number of rows sent in each multi-row INSERT to MySQL
+const mysqlBatchSize = 1000
+
+// Writes the country lookup data to MySQL or MariaDB.  DDL there commits implicitly, so the data is loaded into
+// staging tables which are swapped in by Commit with an atomic RENAME TABLE, the same way as for PostgreSQL
+type mysqlWriter struct {
+	db      *sql.DB
+	created []ipFamily
+}
+
+// Opens the writer for the MySQL server in the [target] section of the config file
+func openMySQLWriter() (w *mysqlWriter, err error) {
+	db, err := connectMySQL()
+	if err != nil {
+		return
+	}
+	return &mysqlWriter{db: db}, nil
+}
+
+// Connects to the MySQL server in the [target] section of the config file
+func connectMySQL() (db *sql.DB, err error) {
+	cfg := mysql.NewConfig()
+	cfg.User = conf.Target.Username
+	cfg.Passwd = conf.Target.Password
+	cfg.Net = "tcp"
+	cfg.Addr = conf.Target.Server
+	if conf.Target.Port != 0 {
+		cfg.Addr += ":" + strconv.Itoa(conf.Target.Port)
+	}
+	cfg.DBName = conf.Target.Database
+	cfg.Params = map[string]string{"charset": "utf8mb4"}
+	db, err = sql.Open("mysql", cfg.FormatDSN())
+	if err != nil {
+		return
+	}
+	err = db.Ping()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	logger.Debug("Connected to MySQL", "user", cfg.User, "addr", cfg.Addr, "database", cfg.DBName)
+	return
+}
+
+// Returns the MySQL type of the ipfrom and ipto columns for the address family
+func mysqlKeyType(fam ipFamily) string {
+	if fam == familyIPv4 {
+		return "bigint"
+	}
+	return "decimal(39,0)"
+}
+
+// Creates the staging table for the address family, dropping any leftover one
+func (w *mysqlWriter) CreateSchema(fam ipFamily) (err error) {
+	table := stagingTable(fam)
+	_, err = w.db.Exec(fmt.Sprintf(`DROP TABLE IF EXISTS %s`, table))
+	if err != nil {
+		return
+	}
+
+	// The text columns are varchar rather than text, as MySQL can only index text columns by a prefix
+	logger.Info("Creating the new data table", "family", fam.name, "table", table)
+	_, err = w.db.Exec(fmt.Sprintf(`
+		CREATE TABLE %[1]s (
+			ipfrom %[2]s PRIMARY KEY,
+			ipto %[2]s,
+			registry varchar(255),
+			assigned bigint,
+			ctry varchar(255),
+			cntry varchar(255),
+			country varchar(255)
+		) DEFAULT CHARSET = utf8mb4 COMMENT = %[3]s`, table, mysqlKeyType(fam), quoteLiteral(importComment())))
+	if err != nil {
+		return
+	}
+	w.created = append(w.created, fam)
+	return
+}
+
+// Writes the rows into the staging table with multi-row INSERTs, all in one transaction
+func (w *mysqlWriter) BulkInsert(fam ipFamily, rows <-chan oneRow) (count int64, err error) {
+	tx, err := w.db.BeginTx(runCtx, nil)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+	placeholders := "(" + strings.TrimSuffix(strings.Repeat("?, ", len(copyColumns)), ", ") + ")"
+	var args []interface{}
+	flush := func() (err error) {
+		if len(args) == 0 {
+			return
+		}
+		n := len(args) / len(copyColumns)
+		_, err = tx.ExecContext(runCtx, fmt.Sprintf(`INSERT INTO %s (%s) VALUES %s`, stagingTable(fam),
+			strings.Join(copyColumns, ", "), strings.TrimSuffix(strings.Repeat(placeholders+", ", n), ", ")), args...)
+		args = args[:0]
+		return
+	}
+	for row := range rows {
+		args = append(args, fam.keyArg(row.ipFrom), fam.keyArg(row.ipTo), row.registry, row.assigned, row.ctry,
+			row.cntry, row.country)
+		count++
+		if count%mysqlBatchSize == 0 {
+			if err = flush(); err != nil {
+				return
+			}
+		}
+	}
+	if err = flush(); err != nil {
+		return
+	}
+	err = tx.Commit()
+	return
+}
+
+// Creates the configured indexes on the staging table.  MySQL index names are per table, so they don't need
+// renaming after the swap
+func (w *mysqlWriter) BuildIndexes(fam ipFamily) (err error) {
+	for _, dbQuery := range indexSQL(stagingTable(fam), nil) {
+		_, err = w.db.ExecContext(runCtx, dbQuery)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (w *mysqlWriter) Verify(fam ipFamily) (count int, err error) {
+	var written int
+	err = w.db.QueryRow(fmt.Sprintf(`SELECT count(*) FROM %s`, stagingTable(fam))).Scan(&written)
+	if err != nil {
+		return
+	}
+	return checkRowCount(fam, written)
+}
+
+// Swaps the staging tables into place.  A single RENAME TABLE statement is atomic, so anyone querying the live
+// table sees either the old data or the new
+func (w *mysqlWriter) Commit() (err error) {
+	for _, fam := range w.created {
+		logger.Info("Swapping the new data table into place", "family", fam.name, "table", fam.pgTable)
+		var exists bool
+		err = w.db.QueryRow(`
+			SELECT count(*) > 0
+			FROM information_schema.tables
+			WHERE table_schema = database()
+				AND table_name = ?`, fam.pgTable).Scan(&exists)
+		if err != nil {
+			return
+		}
+		if !exists {
+			_, err = w.db.Exec(fmt.Sprintf(`RENAME TABLE %s TO %s`, stagingTable(fam), fam.pgTable))
+			if err != nil {
+				return
+			}
+			continue
+		}
+		old := fam.pgTable + "_old"
+		_, err = w.db.Exec(fmt.Sprintf(`DROP TABLE IF EXISTS %s`, old))
+		if err != nil {
+			return
+		}
+		_, err = w.db.Exec(fmt.Sprintf(`RENAME TABLE %[1]s TO %[2]s, %[3]s TO %[1]s`, fam.pgTable, old,
+			stagingTable(fam)))
+		if err != nil {
+			return
+		}
+		_, err = w.db.Exec(fmt.Sprintf(`DROP TABLE %s`, old))
+		if err != nil {
+			return
+		}
+	}
+	w.created = nil
+	return
+}
+
+// Drops any staging tables which weren't swapped in, then disconnects
+func (w *mysqlWriter) Close() {
+	for _, fam := range w.created {
+		_, err := w.db.Exec(fmt.Sprintf(`DROP TABLE IF EXISTS %s`, stagingTable(fam)))
+		if err != nil {
+			logger.Error("Couldn't drop the staging table", "table", stagingTable(fam), "err", err)
+		}
+	}
+	w.db.Close()
+}
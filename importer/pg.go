@@ -0,0 +1,143 @@
+package importer
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/jackc/pgx"
+)
+
+// Returns the TLS settings for the PostgreSQL connections, following libpq's sslmode values.  Without ssl_mode,
+// ssl = true means verify-full (or require, with ssl_insecure), and the default is disable
+func loadTLSConfig() (tlsConfig *tls.Config, err error) {
+	mode := conf.Pg.SSLMode
+	if mode == "" {
+		switch {
+		case !conf.Pg.SSL:
+			mode = "disable"
+		case conf.Pg.SSLInsecure:
+			mode = "require"
+		default:
+			mode = "verify-full"
+		}
+	}
+	switch mode {
+	case "disable":
+		return nil, nil
+	case "require", "verify-ca", "verify-full":
+	default:
+		return nil, fmt.Errorf("Unknown ssl_mode '%s'.  Needs to be one of \"disable\", \"require\", \"verify-ca\", or "+
+			"\"verify-full\"", mode)
+	}
+
+	tlsConfig = &tls.Config{ServerName: conf.Pg.Server}
+	if conf.Pg.SSLCA != "" {
+		var pem []byte
+		pem, err = os.ReadFile(conf.Pg.SSLCA)
+		if err != nil {
+			return nil, fmt.Errorf("Couldn't read the ssl_ca certificate file: %v", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("No certificates could be parsed from the ssl_ca file '%s'", conf.Pg.SSLCA)
+		}
+	}
+
+	// Present a client certificate, if one was given
+	if conf.Pg.SSLCert != "" || conf.Pg.SSLKey != "" {
+		var cert tls.Certificate
+		cert, err = tls.LoadX509KeyPair(conf.Pg.SSLCert, conf.Pg.SSLKey)
+		if err != nil {
+			return nil, fmt.Errorf("Couldn't load the ssl_cert and ssl_key client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	switch mode {
+	case "require":
+		tlsConfig.InsecureSkipVerify = true
+	case "verify-ca":
+		// Check the certificate chain, but not the host name.  The standard verification does both, so it's
+		// turned off in favour of doing the chain check here
+		roots := tlsConfig.RootCAs
+		tlsConfig.InsecureSkipVerify = true
+		tlsConfig.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			return verifyCertChain(rawCerts, roots)
+		}
+	}
+	return
+}
+
+// Verifies a server's certificate chain against the given CA certificates (or the system ones, when nil),
+// without checking the host name
+func verifyCertChain(rawCerts [][]byte, roots *x509.CertPool) error {
+	if len(rawCerts) == 0 {
+		return fmt.Errorf("The server didn't send a certificate")
+	}
+	var certs []*x509.Certificate
+	for _, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return err
+		}
+		certs = append(certs, cert)
+	}
+	intermediates := x509.NewCertPool()
+	for _, c := range certs[1:] {
+		intermediates.AddCert(c)
+	}
+	_, err := certs[0].Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates})
+	return err
+}
+
+// Connects to the PostgreSQL server
+func connectPG() (err error) {
+	// Setup the PostgreSQL config
+	pgConfig := new(pgx.ConnConfig)
+	pgConfig.Host = conf.Pg.Server
+	pgConfig.Port = uint16(conf.Pg.Port)
+	pgConfig.User = conf.Pg.Username
+	pgConfig.Password = conf.Pg.Password
+	pgConfig.Database = conf.Pg.Database
+
+	// Use a consistent encoding and time zone, whatever the server defaults are
+	pgConfig.RuntimeParams = map[string]string{
+		"client_encoding": "UTF8",
+		"timezone":        "UTC",
+	}
+	if conf.Pg.ClientEncoding != "" {
+		pgConfig.RuntimeParams["client_encoding"] = conf.Pg.ClientEncoding
+	}
+	if conf.Pg.Timezone != "" {
+		pgConfig.RuntimeParams["timezone"] = conf.Pg.Timezone
+	}
+	pgConfig.TLSConfig = pgTLS
+
+	// Check the connections are still alive while the import is running, when asked to
+	if heartbeatInterval > 0 {
+		pgConfig.Dial = heartbeatDialer(heartbeatInterval).Dial
+	}
+
+	// Connect to PG
+	pgPoolConfig := pgx.ConnPoolConfig{*pgConfig, numConnections(), nil, 5 * time.Second}
+	pg, err = pgx.NewConnPool(pgPoolConfig)
+	if err != nil {
+		return
+	}
+
+	// Log successful connection
+	logger.Debug("Connected to PostgreSQL", "server", pgConnInfo())
+	return
+}
+
+// Describes the PostgreSQL connection details for logging.  The password is always masked
+func pgConnInfo() string {
+	password := ""
+	if conf.Pg.Password != "" {
+		password = ":" + redactedPassword
+	}
+	return fmt.Sprintf("%s%s@%s:%d/%s", conf.Pg.Username, password, conf.Pg.Server, conf.Pg.Port, conf.Pg.Database)
+}
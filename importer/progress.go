@@ -0,0 +1,38 @@
+package importer
+
+import "time"
+
+// Logs how far through an address family the import is, along with the rate and the estimated time remaining
+func logProgress(fam ipFamily, count, total int, start time.Time) {
+	elapsed := time.Since(start)
+	rate := float64(count) / elapsed.Seconds()
+	var eta time.Duration
+	if rate > 0 && total > count {
+		eta = time.Duration(float64(total-count) / rate * float64(time.Second))
+	}
+	logger.Info("Import progress", "family", fam.name, "rows", count, "total", total, "rows_per_sec", int(rate),
+		"elapsed", elapsed.Round(time.Second).String(), "eta", eta.Round(time.Second).String())
+}
+
+// How long one phase of the import took
+type PhaseDuration struct {
+	Name     string  `json:"name"`
+	Duration float64 `json:"duration_seconds"`
+}
+
+// Marks the start of the next phase of the import, finishing the current one
+func (s *Result) startPhase(name string) {
+	s.endPhase()
+	s.Phases = append(s.Phases, PhaseDuration{Name: name})
+	s.phaseStart = time.Now()
+	s.inPhase = true
+}
+
+// Records how long the current phase took, if one is running
+func (s *Result) endPhase() {
+	if !s.inPhase {
+		return
+	}
+	s.Phases[len(s.Phases)-1].Duration = time.Since(s.phaseStart).Seconds()
+	s.inPhase = false
+}
@@ -0,0 +1,99 @@
+package importer
+
+import (
+	"fmt"
+
+	"github.com/jackc/pgx"
+)
+
+// Schema modes, adding a range column to the lookup tables for containment lookups with a GiST index
+const (
+	schemaRange = "range" // int8range for IPv4, numrange for IPv6
+	schemaIP4R  = "ip4r"  // ip4r and ip6r, from the ip4r extension
+)
+
+// Checks the configured schema mode is valid
+func validateSchemaMode() error {
+	switch conf.Pg.SchemaMode {
+	case "":
+		return nil
+	case schemaRange, schemaIP4R:
+	default:
+		return fmt.Errorf("Unknown schema_mode '%s'.  Needs to be either \"%s\" or \"%s\"", conf.Pg.SchemaMode,
+			schemaRange, schemaIP4R)
+	}
+	if conf.FDW.Server != "" {
+		return fmt.Errorf("schema_mode can't be used with a foreign table")
+	}
+	return nil
+}
+
+// Checks the configured schema mode can be used in the database
+func checkSchemaMode(tx *pgx.Tx) (err error) {
+	err = validateSchemaMode()
+	if err != nil {
+		return
+	}
+	if conf.Pg.SchemaMode == schemaIP4R {
+		var installed bool
+		err = tx.QueryRow(`SELECT count(*) > 0 FROM pg_extension WHERE extname = 'ip4r'`).Scan(&installed)
+		if err != nil {
+			return
+		}
+		if !installed {
+			return fmt.Errorf("schema_mode \"ip4r\" needs the ip4r extension.  Install it with CREATE EXTENSION ip4r")
+		}
+	}
+	return
+}
+
+// Returns the DDL for the range column of a lookup table, when the schema mode has one.  It's a generated column
+// (so PostgreSQL 12 or newer is needed), so loading the data doesn't need to know about it
+func rangeColumnDDL(fam ipFamily) string {
+	var colType, expr string
+	switch conf.Pg.SchemaMode {
+	case schemaRange:
+		colType, expr = "int8range", "int8range(ipfrom, ipto, '[]')"
+		if fam == familyIPv6 {
+			colType, expr = "numrange", "numrange(ipfrom, ipto, '[]')"
+		}
+	case schemaIP4R:
+		colType, expr = "ip4r", "ip4r(ipfrom::ip4, ipto::ip4)"
+		if fam == familyIPv6 {
+			colType, expr = "ip6r", "ip6r(ipfrom::ip6, ipto::ip6)"
+		}
+	default:
+		return ""
+	}
+	return fmt.Sprintf(",\n\t\t\tiprange %s GENERATED ALWAYS AS (%s) STORED", colType, expr)
+}
+
+// Returns the CREATE INDEX statement for the GiST index on the range column of a lookup table
+func rangeIndexSQL(table string) string {
+	return fmt.Sprintf(`CREATE INDEX %s ON %s USING gist (iprange)`, rangeIndexName(table), table)
+}
+
+// Returns the name of the GiST index on the range column of a lookup table
+func rangeIndexName(table string) string {
+	return table + "_iprange_index"
+}
+
+// Returns the WHERE condition for finding the range holding the address given as $1
+func lookupCondition(fam ipFamily) string {
+	switch conf.Pg.SchemaMode {
+	case schemaRange:
+		if fam == familyIPv6 {
+			return "iprange @> $1::numeric"
+		}
+		return "iprange @> $1::bigint"
+	case schemaIP4R:
+		if fam == familyIPv6 {
+			return "iprange >>= $1::numeric::ip6::ip6r"
+		}
+		return "iprange >>= $1::bigint::ip4::ip4r"
+	}
+	if combinedTable {
+		return familyCondition(fam) + " AND $1 BETWEEN ipfrom AND ipto"
+	}
+	return "$1 BETWEEN ipfrom AND ipto"
+}
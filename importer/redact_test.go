@@ -0,0 +1,116 @@
+package importer
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testPassword = "s3cr3t-Pa55"
+
+// Sets the PostgreSQL password for a test, and sends the logger output to the returned buffer
+func withTestSecrets(t *testing.T, level slog.Level) *bytes.Buffer {
+	oldConf, oldLogger, oldWarnings, oldCount := conf, logger, warnings, warningCount
+	t.Cleanup(func() { conf, logger, warnings, warningCount = oldConf, oldLogger, oldWarnings, oldCount })
+	conf.Pg.Password = testPassword
+	conf.Pg.Username, conf.Pg.Server, conf.Pg.Port, conf.Pg.Database = "importer", "db.example.org", 5432, "geoip"
+	var buf bytes.Buffer
+	logger = slog.New(redactingHandler{slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: level})})
+	return &buf
+}
+
+func TestRedactPassword(t *testing.T) {
+	withTestSecrets(t, slog.LevelInfo)
+	tests := []struct{ in, want string }{
+		{"password authentication failed: " + testPassword, "password authentication failed: ****"},
+		{"postgres://importer:" + testPassword + "@db/geoip", "postgres://importer:****@db/geoip"},
+		{"'" + testPassword + "'", "'****'"},
+		{"Cannot connect as admin", "Cannot connect as admin"},
+	}
+	for _, tt := range tests {
+		if got := redactPassword(tt.in); got != tt.want {
+			t.Errorf("redactPassword(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+
+	// Short secrets, and ones inside other words, are masked too
+	conf.Pg.Password = "a"
+	for _, tt := range []struct{ in, want string }{
+		{"Cannot connect as admin", "C****nnot connect ****s ****dmin"},
+		{"https://user:a@example.org/file", "https://user:****@ex****mple.org/file"},
+	} {
+		if got := redactPassword(tt.in); got != tt.want {
+			t.Errorf("redactPassword(%q) with a short password = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+	conf.Pg.Password = "admin"
+	if got := redactPassword("Cannot connect as administrator, or as admin"); got != "Cannot connect as ****istrator, or as ****" {
+		t.Errorf("redactPassword() didn't mask the password inside a word: %q", got)
+	}
+
+	// A secret containing another one is masked whole
+	conf.Pg.Password, conf.Geo.LicenseKey = "key", "licence-key"
+	if got := redactPassword("bad licence-key"); got != "bad ****" {
+		t.Errorf("redactPassword() with overlapping secrets = %q, want %q", got, "bad ****")
+	}
+}
+
+func TestPgConnInfoHidesPassword(t *testing.T) {
+	withTestSecrets(t, slog.LevelDebug)
+	info := pgConnInfo()
+	if strings.Contains(info, testPassword) {
+		t.Errorf("pgConnInfo() = %q, which includes the password", info)
+	}
+	if !strings.Contains(info, "importer:****@db.example.org") {
+		t.Errorf("pgConnInfo() = %q, want the masked user info", info)
+	}
+}
+
+func TestLogOutputHidesPassword(t *testing.T) {
+	for _, level := range []slog.Level{slog.LevelDebug, slog.LevelInfo} {
+		buf := withTestSecrets(t, level)
+		err := errors.New("FATAL: password authentication failed for user \"importer\" with " + testPassword)
+		warn("Connection problem: %v\n", err)
+		logger.Error("Rolling back the import failed", "err", err)
+		logger.Debug("Connected", "dsn", "postgres://importer:"+testPassword+"@db/geoip")
+		logger.With("password", testPassword).Info("With attributes")
+		logger.Info("Grouped", slog.Group("pg", "password", testPassword))
+		if strings.Contains(buf.String(), testPassword) {
+			t.Errorf("log output at level %v includes the password:\n%s", level, buf.String())
+		}
+		for _, w := range warnings {
+			if strings.Contains(w, testPassword) {
+				t.Errorf("recorded warning includes the password: %q", w)
+			}
+		}
+	}
+}
+
+func TestWebhookHidesPassword(t *testing.T) {
+	withTestSecrets(t, slog.LevelInfo)
+	var body []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer srv.Close()
+
+	// The summary fields are normally masked when they're filled in, so this checks postWebhook doesn't rely on that
+	summary := Result{Error: "connecting failed with " + testPassword, Source: "geo.sqlite",
+		Warnings: []string{"postgres://importer:" + testPassword + "@db"}}
+	for _, slack := range []bool{false, true} {
+		webhookSlack = slack
+		postWebhook(srv.URL, summary)
+		if len(body) == 0 {
+			t.Fatal("the webhook wasn't called")
+		}
+		if bytes.Contains(body, []byte(testPassword)) {
+			t.Errorf("webhook body (slack %v) includes the password: %s", slack, body)
+		}
+	}
+	webhookSlack = false
+}
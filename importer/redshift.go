@@ -0,0 +1,229 @@
+package importer
+
+import (
+	"compress/gzip"
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+// Port Redshift clusters listen on, when the config file doesn't give one
+const defaultRedshiftPort = 5439
+
+// Width of the IPv6 ipfrom and ipto columns in Redshift.  Its decimals only go up to 38 digits, which isn't enough
+// for the largest IPv6 addresses, so they're stored as zero padded strings of the 39 digit decimal instead.  Those
+// sort the same way as the numbers, so range lookups still work
+const redshiftIPv6Width = 39
+
+// Writes the country lookup data to Amazon Redshift.  Inserting rows one statement at a time is very slow there,
+// so each address family's rows are written to a gzipped CSV file, uploaded to S3, and loaded with COPY.  Redshift
+// DDL is transactional, so the staging tables are created, loaded, and swapped in within one transaction, the
+// same as for PostgreSQL
+type redshiftWriter struct {
+	db        *sql.DB
+	tx        *sql.Tx
+	bucket    s3Bucket
+	created   []ipFamily
+	committed bool
+}
+
+// Connects to the Redshift cluster in the [target] section of the config file
+func openRedshiftWriter() (w *redshiftWriter, err error) {
+	port := conf.Target.Port
+	if port == 0 {
+		port = defaultRedshiftPort
+	}
+	dsn := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(conf.Target.Username, conf.Target.Password),
+		Host:     net.JoinHostPort(conf.Target.Server, strconv.Itoa(port)),
+		Path:     "/" + conf.Target.Database,
+		RawQuery: "sslmode=require",
+	}
+	db, err := sql.Open("postgres", dsn.String())
+	if err != nil {
+		return
+	}
+	tx, err := db.BeginTx(runCtx, nil)
+	if err != nil {
+		db.Close()
+		return
+	}
+	logger.Debug("Connected to Redshift", "user", conf.Target.Username, "addr", dsn.Host, "database",
+		conf.Target.Database)
+	return &redshiftWriter{db: db, tx: tx, bucket: targetBucket()}, nil
+}
+
+// Returns the Redshift type of the ipfrom and ipto columns for the address family
+func redshiftKeyType(fam ipFamily) string {
+	if fam == familyIPv4 {
+		return "bigint"
+	}
+	return fmt.Sprintf("char(%d)", redshiftIPv6Width)
+}
+
+// Returns an ipfrom or ipto value in the form stored in the address family's Redshift columns
+func redshiftKey(fam ipFamily, n ipNum) string {
+	s := n.String()
+	if fam == familyIPv6 && len(s) < redshiftIPv6Width {
+		s = strings.Repeat("0", redshiftIPv6Width-len(s)) + s
+	}
+	return s
+}
+
+// Creates the staging table for the address family, dropping any leftover one.  Redshift has no indexes, so the
+// table is sorted on ipfrom instead, which lets range lookups skip most of its blocks
+func (w *redshiftWriter) CreateSchema(fam ipFamily) (err error) {
+	table := stagingTable(fam)
+	_, err = w.tx.ExecContext(runCtx, fmt.Sprintf(`DROP TABLE IF EXISTS %s`, table))
+	if err != nil {
+		return
+	}
+	logger.Info("Creating the new data table", "family", fam.name, "table", table)
+	_, err = w.tx.ExecContext(runCtx, fmt.Sprintf(`
+		CREATE TABLE %[1]s (
+			ipfrom %[2]s NOT NULL PRIMARY KEY,
+			ipto %[2]s,
+			registry varchar(255),
+			assigned bigint,
+			ctry varchar(255),
+			cntry varchar(255),
+			country varchar(255)
+		) SORTKEY (ipfrom)`, table, redshiftKeyType(fam)))
+	if err != nil {
+		return
+	}
+	_, err = w.tx.ExecContext(runCtx, fmt.Sprintf(`COMMENT ON TABLE %s IS %s`, table,
+		quoteLiteral(importComment())))
+	if err != nil {
+		return
+	}
+	w.created = append(w.created, fam)
+	return
+}
+
+// Writes the rows to a gzipped CSV file, uploads that to S3, and loads it into the staging table with COPY.  The
+// file and the S3 object are removed afterwards, whether or not the load worked
+func (w *redshiftWriter) BulkInsert(fam ipFamily, rows <-chan oneRow) (count int64, err error) {
+	f, err := os.CreateTemp("", "geoip-redshift-*.csv.gz")
+	if err != nil {
+		return
+	}
+	defer os.Remove(f.Name())
+	count, err = writeRedshiftCSV(f, fam, rows)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return
+	}
+
+	key := path.Join(conf.Target.S3Prefix, fmt.Sprintf("%s-%s.csv.gz", stagingTable(fam),
+		time.Now().UTC().Format("20060102T150405")))
+	logger.Info("Uploading the data to S3", "family", fam.name, "bucket", w.bucket.name, "key", key)
+	err = w.bucket.put(key, f.Name())
+	if err != nil {
+		return
+	}
+	defer func() {
+		if delErr := w.bucket.delete(key); delErr != nil {
+			logger.Error("Couldn't remove the uploaded data from S3", "bucket", w.bucket.name, "key", key, "err",
+				delErr)
+		}
+	}()
+
+	logger.Info("Loading the data into Redshift", "family", fam.name, "table", stagingTable(fam))
+	_, err = w.tx.ExecContext(runCtx, fmt.Sprintf(`COPY %s (%s) FROM %s %s CSV GZIP REGION %s`, stagingTable(fam),
+		strings.Join(copyColumns, ", "), quoteLiteral(s3URL(w.bucket.name, key)), redshiftCredentials(w.bucket),
+		quoteLiteral(w.bucket.region)))
+	return
+}
+
+// Writes the rows to a gzipped CSV file, in the column order of copyColumns
+func writeRedshiftCSV(f *os.File, fam ipFamily, rows <-chan oneRow) (count int64, err error) {
+	gz := gzip.NewWriter(f)
+	c := csv.NewWriter(gz)
+	for row := range rows {
+		err = c.Write([]string{redshiftKey(fam, row.ipFrom), redshiftKey(fam, row.ipTo), row.registry,
+			strconv.Itoa(row.assigned), row.ctry, row.cntry, row.country})
+		if err != nil {
+			return
+		}
+		count++
+	}
+	c.Flush()
+	if err = c.Error(); err != nil {
+		return
+	}
+	err = gz.Close()
+	return
+}
+
+// Returns the authorisation clause of the COPY.  An IAM role attached to the cluster is preferred, falling back
+// to the access keys used for the upload
+func redshiftCredentials(b s3Bucket) string {
+	if conf.Target.IAMRole != "" {
+		return "IAM_ROLE " + quoteLiteral(conf.Target.IAMRole)
+	}
+	return fmt.Sprintf("ACCESS_KEY_ID %s SECRET_ACCESS_KEY %s", quoteLiteral(b.accessKey),
+		quoteLiteral(b.secretKey))
+}
+
+// Redshift has no indexes to build, as the staging table is sorted on ipfrom instead
+func (w *redshiftWriter) BuildIndexes(fam ipFamily) error {
+	if len(indexSpecs) > 0 {
+		logger.Debug("Redshift has no indexes, so the table is sorted on ipfrom instead", "family", fam.name)
+	}
+	return nil
+}
+
+func (w *redshiftWriter) Verify(fam ipFamily) (count int, err error) {
+	var written int
+	err = w.tx.QueryRowContext(runCtx, fmt.Sprintf(`SELECT count(*) FROM %s`, stagingTable(fam))).Scan(&written)
+	if err != nil {
+		return
+	}
+	return checkRowCount(fam, written)
+}
+
+// Swaps the staging tables into place and commits.  Until then, anyone querying the live tables sees the old data
+func (w *redshiftWriter) Commit() (err error) {
+	for _, fam := range w.created {
+		logger.Info("Swapping the new data table into place", "family", fam.name, "table", fam.pgTable)
+		_, err = w.tx.ExecContext(runCtx, fmt.Sprintf(`DROP TABLE IF EXISTS %s`, fam.pgTable))
+		if err != nil {
+			return
+		}
+		_, err = w.tx.ExecContext(runCtx, fmt.Sprintf(`ALTER TABLE %s RENAME TO %s`, stagingTable(fam),
+			fam.pgTable))
+		if err != nil {
+			return
+		}
+	}
+	err = w.tx.Commit()
+	if err != nil {
+		return
+	}
+	w.committed = true
+	return
+}
+
+// Rolls back the transaction if it wasn't committed, which drops the staging tables, then disconnects
+func (w *redshiftWriter) Close() {
+	if !w.committed {
+		err := w.tx.Rollback()
+		if err != nil {
+			logger.Error("Rolling back the import failed", "err", err)
+		}
+	}
+	w.db.Close()
+}
@@ -0,0 +1,187 @@
+package importer
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The S3 upload and delete requests don't take long, apart from the upload of a large file
+var s3Client = &http.Client{Timeout: 30 * time.Minute}
+
+// An S3 bucket, written to through the REST API with requests signed using AWS Signature Version 4.  That's all the
+// Redshift target needs, so it isn't worth depending on the AWS SDK for
+type s3Bucket struct {
+	name      string
+	region    string
+	endpoint  string // Path style endpoint, for S3 compatible servers.  Defaults to the bucket's AWS endpoint
+	accessKey string
+	secretKey string
+}
+
+// Returns the S3 bucket in the [target] section of the config file.  The access keys default to the standard AWS
+// environment variables
+func targetBucket() s3Bucket {
+	return s3Bucket{
+		name:      conf.Target.S3Bucket,
+		region:    conf.Target.S3Region,
+		endpoint:  conf.Target.S3Endpoint,
+		accessKey: s3AccessKey(conf),
+		secretKey: s3SecretKey(conf),
+	}
+}
+
+// Returns the S3 access key ID of the settings, falling back to AWS_ACCESS_KEY_ID
+func s3AccessKey(cfg Config) string {
+	if cfg.Target.S3AccessKey != "" {
+		return cfg.Target.S3AccessKey
+	}
+	return os.Getenv("AWS_ACCESS_KEY_ID")
+}
+
+// Returns the S3 secret access key of the settings, falling back to AWS_SECRET_ACCESS_KEY
+func s3SecretKey(cfg Config) string {
+	if cfg.Target.S3SecretKey != "" {
+		return cfg.Target.S3SecretKey
+	}
+	return os.Getenv("AWS_SECRET_ACCESS_KEY")
+}
+
+// Returns the URL of an object in the bucket
+func (b s3Bucket) objectURL(key string) string {
+	if b.endpoint != "" {
+		return strings.TrimSuffix(b.endpoint, "/") + "/" + b.name + "/" + s3EscapePath(key)
+	}
+	return fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", b.name, b.region, s3EscapePath(key))
+}
+
+// Uploads the file to the bucket, under the given key
+func (b s3Bucket) put(key, path string) (err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	hash := sha256.New()
+	size, err := io.Copy(hash, f)
+	if err != nil {
+		return
+	}
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		return
+	}
+	req, err := http.NewRequestWithContext(runCtx, http.MethodPut, b.objectURL(key), f)
+	if err != nil {
+		return
+	}
+	req.ContentLength = size
+	return b.do(req, hex.EncodeToString(hash.Sum(nil)))
+}
+
+// Deletes an object from the bucket.  This isn't stopped by cancelling the import, as it's cleaning up after it
+func (b s3Bucket) delete(key string) (err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, b.objectURL(key), nil)
+	if err != nil {
+		return
+	}
+	return b.do(req, emptyPayloadHash)
+}
+
+// Signs and sends a request, failing unless S3 accepted it
+func (b s3Bucket) do(req *http.Request, payloadHash string) (err error) {
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	req.Header.Set("X-Amz-Date", time.Now().UTC().Format(amzDateFormat))
+	signS3Request(req, b.region, b.accessKey, b.secretKey)
+	resp, err := s3Client.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("S3 %s of '%s' failed: %s: %s", req.Method, req.URL.Path, resp.Status,
+			strings.TrimSpace(string(body)))
+	}
+	return
+}
+
+// Timestamp format of the X-Amz-Date header
+const amzDateFormat = "20060102T150405Z"
+
+// SHA-256 of an empty request body
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// Adds the AWS Signature Version 4 Authorization header to an S3 request.  The X-Amz-Date and X-Amz-Content-Sha256
+// headers need to be set already.  The host and all of the request's headers are signed
+func signS3Request(req *http.Request, region, accessKey, secretKey string) {
+	amzDate := req.Header.Get("X-Amz-Date")
+	date := amzDate[:8]
+
+	// The canonical form of the request.  S3 doesn't normalise the path, so it's used as sent
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+	canonicalRequest := strings.Join([]string{req.Method, req.URL.EscapedPath(), req.URL.Query().Encode(),
+		canonicalHeaders.String(), signedHeaders, req.Header.Get("X-Amz-Content-Sha256")}, "\n")
+
+	// Sign it with a key derived from the secret for the day, region and service
+	scope := date + "/" + region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+	key := []byte("AWS4" + secretKey)
+	for _, part := range []string{date, region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", accessKey, scope, signedHeaders,
+		signature))
+}
+
+// Returns the HMAC-SHA256 of the data with the given key
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// Escapes an object key for the request path, the way S3 expects.  Unlike url.PathEscape, everything other than
+// the unreserved characters and the slashes is escaped
+func s3EscapePath(key string) string {
+	var b strings.Builder
+	for _, c := range []byte(key) {
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', strings.IndexByte("-_.~/", c) >= 0:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// Returns the s3:// URL of an object, as given to the Redshift COPY
+func s3URL(bucket, key string) string {
+	return "s3://" + bucket + "/" + key
+}
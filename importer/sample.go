@@ -0,0 +1,123 @@
+package importer
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+
+	sqlite "github.com/gwenn/gosqlite"
+)
+
+// Returned from a row callback to stop reading the source early
+var errStopReading = errors.New("stop reading")
+
+// Shows the structure of the Geo-IP source, and the first few records decoded from it.  Doesn't need PostgreSQL
+func sourceSample(args []string) (err error) {
+	fs := flag.NewFlagSet("source-sample", flag.ContinueOnError)
+	n := fs.Int("n", 20, "Number of records to show")
+	err = fs.Parse(args)
+	if err != nil {
+		return
+	}
+
+	closeSource, err := openSource()
+	if err != nil {
+		return
+	}
+	defer closeSource()
+
+	// Describe the structure of the source
+	switch src := ranges.(type) {
+	case *ip2locationBIN:
+		fmt.Printf("IP2Location BIN file.  DB type: %d, columns per record: %d, database date: 20%02d-%02d-%02d, "+
+			"IPv4 records: %d, IPv6 records: %d\n", src.dbType, src.dbColumn, src.year, src.month, src.day, src.ipv4Count,
+			src.ipv6Count)
+	case *rangeList:
+		fmt.Printf("%s source.  IPv4 ranges: %d, IPv6 ranges: %d\n", src.format, len(src.ipv4), len(src.ipv6))
+	default:
+		err = describeSQLite(sdb)
+		if err != nil {
+			return
+		}
+	}
+
+	// Show the first records of each address family, as the importer sees them
+	for _, fam := range families {
+		var has bool
+		has, err = sourceHasFamily(fam)
+		if err != nil {
+			return
+		}
+		if !has {
+			fmt.Printf("\nNo %s records\n", fam.name)
+			continue
+		}
+		fmt.Printf("\nFirst %d %s record(s):\n", *n, fam.name)
+		fmt.Printf("%-12s %-12s %-10s %-12s %-5s %-6s %s\n", "ipfrom", "ipto", "registry", "assigned", "ctry",
+			"cntry", "country")
+		var count int
+		err = readSource(fam, func(row oneRow) error {
+			if count >= *n {
+				return errStopReading
+			}
+			count++
+			fmt.Printf("%-12v %-12v %-10s %-12d %-5s %-6s %s\n", row.ipFrom, row.ipTo, row.registry, row.assigned,
+				row.ctry, row.cntry, row.country)
+			return nil
+		})
+		if err == errStopReading {
+			err = nil
+		}
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Lists the tables and columns of a SQLite Geo-IP database, along with the types of the values actually stored
+// in the ipv4 table
+func describeSQLite(conn *sqlite.Conn) (err error) {
+	var tables []string
+	err = conn.Select(`SELECT name FROM sqlite_master WHERE type = 'table' ORDER BY name`, func(s *sqlite.Stmt) error {
+		var name string
+		if err := s.Scan(&name); err != nil {
+			return err
+		}
+		tables = append(tables, name)
+		return nil
+	})
+	if err != nil {
+		return
+	}
+	for _, t := range tables {
+		fmt.Printf("Table: %s\n", t)
+		err = conn.Select(`SELECT name, type FROM pragma_table_info(?)`, func(s *sqlite.Stmt) error {
+			var name, colType string
+			if err := s.Scan(&name, &colType); err != nil {
+				return err
+			}
+			fmt.Printf("  %-10s %s\n", name, colType)
+			return nil
+		}, t)
+		if err != nil {
+			return
+		}
+	}
+
+	// The declared column types don't always match what's stored, so show the types of the first record's values
+	sQuery := `
+		SELECT typeof(IPFROM), typeof(IPTO), typeof(REGISTRY), typeof(ASSIGNED), typeof(CTRY), typeof(CNTRY),
+			typeof(COUNTRY)
+		FROM ipv4
+		LIMIT 1`
+	return conn.Select(sQuery, func(s *sqlite.Stmt) error {
+		types := make([]string, 7)
+		if err := s.Scan(&types[0], &types[1], &types[2], &types[3], &types[4], &types[5], &types[6]); err != nil {
+			return err
+		}
+		fmt.Printf("Detected ipv4 value types: IPFROM %s, IPTO %s, REGISTRY %s, ASSIGNED %s, CTRY %s, CNTRY %s, "+
+			"COUNTRY %s\n", types[0], types[1], types[2], types[3], types[4], types[5], types[6])
+		return nil
+	})
+}
@@ -0,0 +1,219 @@
+package importer
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	sqlite "github.com/gwenn/gosqlite"
+)
+
+// Opens the configured Geo-IP source.  The returned function closes it again
+func openSource() (closeFn func(), err error) {
+	format := conf.Geo.Format
+	if format == "" {
+		format = formatSQLite
+	}
+	if _, ok := defaultMemberPatterns[format]; !ok {
+		err = fmt.Errorf("Unknown Geo-IP source format '%s'", conf.Geo.Format)
+		return
+	}
+
+	// If the source is a tar archive, extract the Geo-IP file from it first
+	path := conf.Geo.Path
+	cleanup := func() {}
+	if isTarball(path) {
+		if format == formatGeoLite2 {
+			err = fmt.Errorf("GeoLite2 sources need to be a directory holding the CSV files, not an archive")
+			return
+		}
+		pattern := conf.Geo.Member
+		if pattern == "" {
+			pattern = defaultMemberPatterns[format]
+		}
+		path, err = extractMember(conf.Geo.Path, pattern)
+		if err != nil {
+			return
+		}
+		cleanup = func() {
+			err := os.Remove(path)
+			if err != nil {
+				logger.Error("Couldn't remove the extracted Geo-IP file", "path", path, "err", err)
+			}
+		}
+	}
+
+	switch format {
+	case formatSQLite:
+		sdb, err = openSQLite(path, conf.Geo.OpenRetries)
+		if err != nil {
+			cleanup()
+			return
+		}
+		closeFn = func() {
+			err := sdb.Close()
+			if err != nil {
+				logger.Error("Couldn't close the Geo-IP database", "err", err)
+			}
+			sdb = nil
+			cleanup()
+		}
+	default:
+		// The other formats are read in Go.  Apart from the BIN files, they're text, so they're loaded into memory
+		// to be sorted
+		switch format {
+		case formatIP2LocationBIN:
+			ranges, err = openIP2LocationBIN(path)
+		case formatIP2LocationCSV:
+			ranges, err = loadIP2LocationCSV(path)
+		case formatGeoLite2:
+			ranges, err = loadGeoLite2(path)
+		case formatRIR:
+			ranges, err = loadRIRDelegated(path)
+		}
+		if err != nil {
+			ranges = nil
+			cleanup()
+			return
+		}
+		closeFn = func() {
+			ranges = nil
+			cleanup()
+		}
+	}
+
+	// Log successful connection
+	logger.Debug("Connected to the Geo-IP database", "path", conf.Geo.Path)
+	return
+}
+
+// Opens the Geo-IP.sqlite file and checks it has the expected schema.  If the file is still being written by
+// whatever produces it, opening or validating it can fail temporarily, so this is retried with a backoff.  The
+// wait between attempts ends early if the import is cancelled
+func openSQLite(path string, retries int) (conn *sqlite.Conn, err error) {
+	delay := time.Second
+	for attempt := 1; ; attempt++ {
+		logger.Debug("Opening the Geo-IP database", "path", path, "attempt", attempt, "attempts", retries+1)
+		conn, err = sqlite.Open(path, sqlite.OpenReadOnly)
+		if err == nil {
+			err = validateSQLiteSchema(conn)
+			if err == nil {
+				return
+			}
+			conn.Close()
+			conn = nil
+		}
+		if attempt > retries {
+			return
+		}
+		logger.Warn("Opening Geo-IP database failed, retrying", "err", err, "delay", delay)
+		select {
+		case <-runCtx.Done():
+			return nil, runCtx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// Checks the Geo-IP.sqlite file has an ipv4 table with the columns we import
+func validateSQLiteSchema(conn *sqlite.Conn) error {
+	sQuery := `
+		SELECT IPFROM, IPTO, REGISTRY, ASSIGNED, CTRY, CNTRY, COUNTRY
+		FROM ipv4
+		LIMIT 1`
+	err := conn.Select(sQuery, func(s *sqlite.Stmt) error {
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("Geo-IP database doesn't have the expected ipv4 table: %v", err)
+	}
+	return nil
+}
+
+// A Geo-IP source which is read in Go, rather than through SQLite
+type rangeSource interface {
+	hasFamily(fam ipFamily) bool
+	readIPv4(fn func(row oneRow) error) error
+	readIPv6(fn func(row oneRow) error) error
+}
+
+// Returns true if the Geo-IP source has data for the address family.  Older Geo-IP.sqlite files only have the
+// ipv4 table
+func sourceHasFamily(fam ipFamily) (bool, error) {
+	if ranges != nil {
+		return ranges.hasFamily(fam), nil
+	}
+	var count int
+	sQuery := `SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = ?`
+	err := sdb.Select(sQuery, func(s *sqlite.Stmt) error {
+		return s.Scan(&count)
+	}, fam.sourceTable)
+	return count > 0, err
+}
+
+// Reads each record for the address family from the Geo-IP source, passing them in ascending ipfrom order to
+// the given function
+func readSource(fam ipFamily, fn func(row oneRow) error) error {
+	if ranges != nil {
+		if fam == familyIPv6 {
+			return ranges.readIPv6(fn)
+		}
+		return ranges.readIPv4(fn)
+	}
+	if fam == familyIPv4 {
+		sQuery := `
+			SELECT IPFROM, IPTO, REGISTRY, ASSIGNED, CTRY, CNTRY, COUNTRY
+			FROM ipv4
+			ORDER BY IPFROM ASC`
+		return sdb.Select(sQuery, func(s *sqlite.Stmt) (innerErr error) {
+			var row oneRow
+			var ipFrom, ipTo int64
+			innerErr = s.Scan(&ipFrom, &ipTo, &row.registry, &row.assigned, &row.ctry, &row.cntry, &row.country)
+			if innerErr != nil {
+				return
+			}
+			row.ipFrom, row.ipTo = ipNumFromUint64(uint64(ipFrom)), ipNumFromUint64(uint64(ipTo))
+			return fn(row)
+		})
+	}
+
+	// The IPv6 range values are too large for SQLite integers, so they're read as decimal strings.  Ordering by
+	// length first sorts those numerically
+	sQuery := `
+		SELECT IPFROM, IPTO, REGISTRY, ASSIGNED, CTRY, CNTRY, COUNTRY
+		FROM ipv6
+		ORDER BY length(IPFROM), IPFROM ASC`
+	return sdb.Select(sQuery, func(s *sqlite.Stmt) (innerErr error) {
+		var row oneRow
+		var ipFrom, ipTo string
+		innerErr = s.Scan(&ipFrom, &ipTo, &row.registry, &row.assigned, &row.ctry, &row.cntry, &row.country)
+		if innerErr != nil {
+			return
+		}
+		if row.ipFrom, innerErr = parseIPNum(ipFrom); innerErr != nil {
+			return fmt.Errorf("Invalid IPv6 IPFROM value: %v", innerErr)
+		}
+		if row.ipTo, innerErr = parseIPNum(ipTo); innerErr != nil {
+			return fmt.Errorf("Invalid IPv6 IPTO value: %v", innerErr)
+		}
+		return fn(row)
+	})
+}
+
+// Returns the number of records for the address family in the Geo-IP source
+func countSource(fam ipFamily) (count int, err error) {
+	if ranges != nil {
+		err = readSource(fam, func(row oneRow) error {
+			count++
+			return nil
+		})
+		return
+	}
+	sQuery := fmt.Sprintf(`SELECT count(*) FROM %s`, fam.sourceTable)
+	err = sdb.Select(sQuery, func(s *sqlite.Stmt) (innerErr error) {
+		innerErr = s.Scan(&count)
+		return
+	})
+	return
+}
@@ -0,0 +1,117 @@
+package importer
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	sqlite "github.com/gwenn/gosqlite"
+)
+
+// Writes the country lookup data to a SQLite file, using the same layout as the Geo-IP.sqlite source (ipv4 and
+// ipv6 tables, with IPv6 values as decimal strings).  So the result can be imported again with -format sqlite,
+// which is handy for testing.  SQLite DDL is transactional, so everything happens in one transaction and the
+// tables are replaced directly rather than through staging tables
+type sqliteWriter struct {
+	conn      *sqlite.Conn
+	committed bool
+}
+
+// Opens (or creates) the SQLite file in the [target] section of the config file
+func openSQLiteWriter() (w *sqliteWriter, err error) {
+	if filepath.Clean(conf.Target.Path) == filepath.Clean(conf.Geo.Path) {
+		return nil, fmt.Errorf("The sqlite target can't be the Geo-IP source file")
+	}
+	conn, err := sqlite.Open(conf.Target.Path, sqlite.OpenReadWrite, sqlite.OpenCreate)
+	if err != nil {
+		return
+	}
+	err = conn.Begin()
+	if err != nil {
+		conn.Close()
+		return
+	}
+	return &sqliteWriter{conn: conn}, nil
+}
+
+// Replaces the address family's table with an empty one
+func (w *sqliteWriter) CreateSchema(fam ipFamily) (err error) {
+	keyType := "INTEGER"
+	if fam == familyIPv6 {
+		keyType = "TEXT"
+	}
+	err = w.conn.Exec(fmt.Sprintf(`DROP TABLE IF EXISTS %s`, fam.sourceTable))
+	if err != nil {
+		return
+	}
+	logger.Info("Creating the new data table", "family", fam.name, "table", fam.sourceTable)
+	return w.conn.Exec(fmt.Sprintf(`
+		CREATE TABLE %[1]s (
+			IPFROM %[2]s PRIMARY KEY,
+			IPTO %[2]s,
+			REGISTRY TEXT,
+			ASSIGNED INTEGER,
+			CTRY TEXT,
+			CNTRY TEXT,
+			COUNTRY TEXT
+		)`, fam.sourceTable, keyType))
+}
+
+func (w *sqliteWriter) BulkInsert(fam ipFamily, rows <-chan oneRow) (count int64, err error) {
+	stmt, err := w.conn.Prepare(fmt.Sprintf(`INSERT INTO %s (%s) VALUES (?, ?, ?, ?, ?, ?, ?)`, fam.sourceTable,
+		strings.ToUpper(strings.Join(copyColumns, ", "))))
+	if err != nil {
+		return
+	}
+	defer stmt.Finalize()
+	for row := range rows {
+		err = stmt.Exec(fam.keyArg(row.ipFrom), fam.keyArg(row.ipTo), row.registry, row.assigned, row.ctry, row.cntry,
+			row.country)
+		if err != nil {
+			return
+		}
+		count++
+	}
+	return
+}
+
+// Creates the configured indexes.  SQLite identifiers aren't case sensitive, so the lower case column names work
+func (w *sqliteWriter) BuildIndexes(fam ipFamily) (err error) {
+	for _, dbQuery := range indexSQL(fam.sourceTable, nil) {
+		err = w.conn.Exec(dbQuery)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (w *sqliteWriter) Verify(fam ipFamily) (count int, err error) {
+	var written int
+	err = w.conn.OneValue(fmt.Sprintf(`SELECT count(*) FROM %s`, fam.sourceTable), &written)
+	if err != nil {
+		return
+	}
+	return checkRowCount(fam, written)
+}
+
+func (w *sqliteWriter) Commit() (err error) {
+	err = w.conn.Commit()
+	if err != nil {
+		return
+	}
+	w.committed = true
+	return
+}
+
+// Rolls back the transaction if it wasn't committed, then closes the file
+func (w *sqliteWriter) Close() {
+	if !w.committed {
+		if err := w.conn.Rollback(); err != nil {
+			logger.Error("Rolling back the SQLite target failed", "err", err)
+		}
+	}
+	if err := w.conn.Close(); err != nil {
+		logger.Error("Closing the SQLite target failed", "err", err)
+	}
+}
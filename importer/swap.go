@@ -0,0 +1,86 @@
+package importer
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx"
+)
+
+// Returns the name of the staging table an address family's data is loaded into, before being swapped in
+func stagingTable(fam ipFamily) string {
+	return fam.pgTable + "_new"
+}
+
+// Returns the table which holds an address family's new data once it's loaded.  Normally that's the staging
+// table, but foreign tables and upserts go straight into the live one
+func newDataTable(fam ipFamily) string {
+	if upsert || conf.FDW.Server != "" {
+		return fam.pgTable
+	}
+	return stagingTable(fam)
+}
+
+// Replaces an address family's live table with its staging table.  As this is the last step of the import, the
+// exclusive lock needed to drop the live table is only held briefly, instead of for the whole import.  Anyone
+// querying the table sees the old data until the import commits
+func swapTables(tx *pgx.Tx, fam ipFamily, lockTimeout time.Duration) (err error) {
+	logger.Info("Swapping the new data table into place", "family", fam.name, "table", fam.pgTable)
+
+	// Find the staging table's indexes (including the primary key), so they can be renamed along with it
+	staging := stagingTable(fam)
+	rows, err := tx.Query(`
+		SELECT indexname
+		FROM pg_indexes
+		WHERE schemaname = current_schema()
+			AND tablename = $1`, staging)
+	if err != nil {
+		return
+	}
+	var indexes []string
+	for rows.Next() {
+		var name string
+		err = rows.Scan(&name)
+		if err != nil {
+			rows.Close()
+			return
+		}
+		indexes = append(indexes, name)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return
+	}
+
+	for _, dbQuery := range swapSQL(fam, indexes) {
+		_, err = tx.Exec(dbQuery)
+		if pgErr, ok := err.(pgx.PgError); ok && pgErr.Code == lockNotAvailable {
+			return fmt.Errorf("Timed out after %v waiting for a lock on the existing %s table.  It's probably in "+
+				"use by a long running query, so please try again later", lockTimeout, fam.pgTable)
+		}
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Returns the statements swapTables runs, given the names of the staging table's indexes.  These are also what
+// -dry-run shows.  The indexes (and with them the primary key constraint) are renamed to match the live table, so
+// they don't clash with the next import's staging table
+func swapSQL(fam ipFamily, indexes []string) []string {
+	staging := stagingTable(fam)
+	statements := []string{
+		fmt.Sprintf(`DROP TABLE IF EXISTS %s`, fam.pgTable),
+		fmt.Sprintf(`ALTER TABLE %s RENAME TO %s`, staging, fam.pgTable),
+	}
+	for _, name := range indexes {
+		if !strings.HasPrefix(name, staging+"_") {
+			continue
+		}
+		newName := fam.pgTable + strings.TrimPrefix(name, staging)
+		statements = append(statements, fmt.Sprintf(`ALTER INDEX %s RENAME TO %s`, name, newName))
+	}
+	return statements
+}
@@ -0,0 +1,237 @@
+package importer
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/jackc/pgx"
+)
+
+// An extra output table, holding a subset of the columns of one of the main country lookup tables.  Each one is
+// written in parallel on its own connection, from the same read of the Geo-IP source
+type outputTable struct {
+	info      TableInfo
+	fam       ipFamily
+	tx        *pgx.Tx
+	rows      chan oneRow
+	done      chan error
+	writing   bool // Whether the writer goroutine is running, and hasn't yet been waited for
+	committed bool
+}
+
+// Column types of the country lookup table.  The ipfrom and ipto columns take the address family's key type
+// instead, for the IPv6 tables
+var columnTypes = map[string]string{
+	"ipfrom":   "bigint",
+	"ipto":     "bigint",
+	"registry": "text",
+	"assigned": "bigint",
+	"ctry":     "text",
+	"cntry":    "text",
+	"country":  "text",
+}
+
+// Pattern the extra output table names need to match, as they're used unquoted in the SQL
+var tableNameRegex = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+// Default size of the PostgreSQL connection pool, the same as pgx's
+const defaultNumConnections = 5
+
+// Returns the size of the PostgreSQL connection pool.  When num_connections isn't set, the pool is made big
+// enough for the main tables and each extra output table to have a connection
+func numConnections() int {
+	if conf.Pg.NumConnections > 0 {
+		return conf.Pg.NumConnections
+	}
+	if n := len(conf.Tables) + 1; n > defaultNumConnections {
+		return n
+	}
+	return defaultNumConnections
+}
+
+// Returns the address family of the data in an extra output table
+func (info TableInfo) family() (fam ipFamily, err error) {
+	switch strings.ToLower(info.Family) {
+	case "", "ipv4":
+		return familyIPv4, nil
+	case "ipv6":
+		return familyIPv6, nil
+	}
+	err = fmt.Errorf("Unknown family '%s' for output table '%s'.  Needs to be either \"ipv4\" or \"ipv6\"",
+		info.Family, info.Name)
+	return
+}
+
+// Checks the extra output tables in the config file are usable
+func validateOutputTables(tables []TableInfo) error {
+	reserved := make(map[string]bool)
+	for _, fam := range families {
+		reserved[fam.pgTable] = true
+		reserved[stagingTable(fam)] = true
+		reserved[upsertTable(fam)] = true
+	}
+	seen := make(map[string]bool)
+	for _, t := range tables {
+		if !tableNameRegex.MatchString(t.Name) {
+			return fmt.Errorf("Invalid output table name '%s'", t.Name)
+		}
+		if reserved[t.Name] {
+			return fmt.Errorf("Output table '%s' clashes with one of the country lookup tables", t.Name)
+		}
+		if seen[t.Name] {
+			return fmt.Errorf("Output table '%s' is given more than once", t.Name)
+		}
+		seen[t.Name] = true
+		if _, err := t.family(); err != nil {
+			return err
+		}
+		hasIPFrom := false
+		for _, c := range t.Columns {
+			if _, ok := columnTypes[c]; !ok {
+				return fmt.Errorf("Unknown column '%s' for output table '%s'", c, t.Name)
+			}
+			if c == "ipfrom" {
+				hasIPFrom = true
+			}
+		}
+		if !hasIPFrom {
+			return fmt.Errorf("Output table '%s' needs to include the ipfrom column", t.Name)
+		}
+	}
+	if len(tables) > 0 && conf.FDW.Server != "" {
+		return fmt.Errorf("Extra output tables can't be used with a foreign table")
+	}
+	if len(tables) > 0 && numConnections() < len(tables)+1 {
+		return fmt.Errorf("num_connections needs to be at least %d to write %d extra output table(s)",
+			len(tables)+1, len(tables))
+	}
+	return nil
+}
+
+// Creates an extra output table in its own transaction, and starts writing the rows of its address family sent
+// to it
+func startOutputTable(info TableInfo, fam ipFamily) (t *outputTable, err error) {
+	tx, err := pg.BeginEx(runCtx, nil)
+	if err != nil {
+		return
+	}
+	t = &outputTable{info: info, fam: fam, tx: tx, rows: make(chan oneRow, 1000), done: make(chan error, 1)}
+	if _, err = setLockTimeout(tx); err != nil {
+		return
+	}
+	_, err = tx.Exec(fmt.Sprintf(`DROP TABLE IF EXISTS %s`, info.Name))
+	if err != nil {
+		return
+	}
+	var cols []string
+	for _, c := range info.Columns {
+		def := c + " " + columnType(fam, c)
+		if c == "ipfrom" {
+			def += fmt.Sprintf(" constraint %s_pk primary key", info.Name)
+		}
+		cols = append(cols, def)
+	}
+	_, err = tx.Exec(fmt.Sprintf("CREATE TABLE %s (\n%s\n)", info.Name, strings.Join(cols, ",\n")))
+	if err != nil {
+		return
+	}
+	t.writing = true
+	go t.write()
+	return
+}
+
+// Copies each row sent to the output table into it, then creates its indexes once the rows have all arrived
+func (t *outputTable) write() {
+	src := &copySource{rows: t.rows, values: func(row oneRow) []interface{} {
+		var vals []interface{}
+		for _, c := range t.info.Columns {
+			vals = append(vals, columnValue(t.fam, row, c))
+		}
+		return vals
+	}}
+	_, err := t.tx.CopyFrom(pgx.Identifier{t.info.Name}, t.info.Columns, src)
+
+	// Keep draining the rows after a failure, so the source reader doesn't block
+	for range t.rows {
+	}
+	if err == nil {
+		err = createIndexes(t.tx, t.info.Name, t.info.Columns)
+	}
+	t.done <- err
+}
+
+// Waits for the output table to finish writing, then checks it holds the expected number of rows
+func (t *outputTable) finish(expected int) (err error) {
+	err = <-t.done
+	t.writing = false
+	if err != nil {
+		return fmt.Errorf("writing output table '%s' failed: %v", t.info.Name, err)
+	}
+	var count int
+	err = t.tx.QueryRow(fmt.Sprintf(`SELECT count(*) FROM %s`, t.info.Name)).Scan(&count)
+	if err != nil {
+		return fmt.Errorf("error when counting rows in output table '%s': %v", t.info.Name, err)
+	}
+	if count != expected {
+		return fmt.Errorf("Mismatching row counts for output table '%s'.  Source: %d, PostgreSQL: %d",
+			t.info.Name, expected, count)
+	}
+	logger.Debug("Output table row count matches", "table", t.info.Name, "rows", count)
+	return
+}
+
+// Commits the output table's transaction
+func (t *outputTable) commit() (err error) {
+	err = t.tx.Commit()
+	if err != nil {
+		return fmt.Errorf("committing output table '%s' failed: %v", t.info.Name, err)
+	}
+	t.committed = true
+	return
+}
+
+// Rolls back the output table's transaction, if it hasn't been committed.  Any rows still being written are
+// waited for first, as the transaction can't be used from two goroutines at once
+func (t *outputTable) rollback() {
+	if t.committed {
+		return
+	}
+	if t.writing {
+		<-t.done
+		t.writing = false
+	}
+	err := t.tx.Rollback()
+	if err != nil && err != pgx.ErrTxClosed { // Already closed by a failed commit
+		logger.Error("Rolling back the output table failed", "table", t.info.Name, "err", err)
+	}
+}
+
+// Returns the PostgreSQL type of the named column, for the address family
+func columnType(fam ipFamily, column string) string {
+	if column == "ipfrom" || column == "ipto" {
+		return fam.keyType
+	}
+	return columnTypes[column]
+}
+
+// Returns the value of the named column from a data record of the address family, for sending with COPY
+func columnValue(fam ipFamily, row oneRow, column string) interface{} {
+	switch column {
+	case "ipfrom":
+		return fam.copyArg(row.ipFrom)
+	case "ipto":
+		return fam.copyArg(row.ipTo)
+	case "registry":
+		return row.registry
+	case "assigned":
+		return row.assigned
+	case "ctry":
+		return row.ctry
+	case "cntry":
+		return row.cntry
+	case "country":
+		return row.country
+	}
+	return nil
+}
@@ -0,0 +1,287 @@
+package importer
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Geo-IP ranges loaded into memory from one of the text based source formats, sorted by ipfrom
+type rangeList struct {
+	format string
+	ipv4   []oneRow
+	ipv6   []oneRow
+}
+
+// IP2Location CSV files don't say which address family they hold, so anything past the IPv4 address space is
+// taken to be IPv6
+var maxIPv4 = ipNumFromUint64(1<<32 - 1)
+
+// Returns true for IPv4, and for IPv6 if the source had any IPv6 ranges
+func (r *rangeList) hasFamily(fam ipFamily) bool {
+	return fam == familyIPv4 || len(r.ipv6) > 0
+}
+
+func (r *rangeList) readIPv4(fn func(row oneRow) error) error {
+	return readRows(r.ipv4, fn)
+}
+
+func (r *rangeList) readIPv6(fn func(row oneRow) error) error {
+	return readRows(r.ipv6, fn)
+}
+
+// Passes each of the rows to the given function, stopping at the first error
+func readRows(rows []oneRow, fn func(row oneRow) error) error {
+	for _, row := range rows {
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Sorts the loaded ranges by ipfrom, as the text formats don't guarantee any order
+func (r *rangeList) sort() {
+	for _, rows := range [][]oneRow{r.ipv4, r.ipv6} {
+		sort.Slice(rows, func(i, j int) bool { return rows[i].ipFrom.cmp(rows[j].ipFrom) < 0 })
+	}
+}
+
+// Loads an IP2Location LITE CSV file (eg IP2LOCATION-LITE-DB1.CSV or its IPv6 equivalent).  The first 4 columns
+// are the start and end of the range, the country code, and the country name.  Any further columns, as in the
+// higher DB types, are ignored
+func loadIP2LocationCSV(path string) (r *rangeList, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	r = &rangeList{format: formatIP2LocationCSV}
+	c := csv.NewReader(bufio.NewReader(f))
+	c.FieldsPerRecord = -1
+	for line := 1; ; line++ {
+		var rec []string
+		rec, err = c.Read()
+		if err == io.EOF {
+			err = nil
+			break
+		}
+		if err != nil {
+			return
+		}
+		if len(rec) < 4 {
+			return nil, fmt.Errorf("line %d of '%s' has %d columns, but needs at least 4", line, path, len(rec))
+		}
+
+		// Ranges which aren't assigned to a country are skipped, as they're not useful for lookups
+		if rec[2] == "-" {
+			continue
+		}
+		row := oneRow{ctry: rec[2], country: rec[3]}
+		if row.ipFrom, err = parseIPNum(rec[0]); err != nil {
+			return nil, fmt.Errorf("line %d of '%s': %v", line, path, err)
+		}
+		if row.ipTo, err = parseIPNum(rec[1]); err != nil {
+			return nil, fmt.Errorf("line %d of '%s': %v", line, path, err)
+		}
+		if row.ipTo.cmp(maxIPv4) <= 0 {
+			r.ipv4 = append(r.ipv4, row)
+		} else {
+			r.ipv6 = append(r.ipv6, row)
+		}
+	}
+	r.sort()
+	return
+}
+
+// Loads the MaxMind GeoLite2 Country (or City) CSV files from a directory.  The country of each network block
+// is looked up in the English locations file, falling back to the registered country when the block has no
+// country of its own
+func loadGeoLite2(dir string) (r *rangeList, err error) {
+	find := func(pattern string) (string, error) {
+		matches, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			return "", err
+		}
+		if len(matches) == 0 {
+			return "", fmt.Errorf("no file matching '%s' in the GeoLite2 directory '%s'", pattern, dir)
+		}
+		return matches[0], nil
+	}
+
+	// Load the country codes and names
+	locPath, err := find("*-Locations-en.csv")
+	if err != nil {
+		return
+	}
+	type location struct{ ctry, country string }
+	locations := make(map[string]location)
+	err = readCSVWithHeader(locPath, func(get func(col string) string) error {
+		if code := get("country_iso_code"); code != "" {
+			locations[get("geoname_id")] = location{ctry: code, country: get("country_name")}
+		}
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	// Load the network blocks for each address family
+	r = &rangeList{format: formatGeoLite2}
+	for _, fam := range families {
+		var blocksPath string
+		blocksPath, err = find("*-Blocks-" + fam.name + ".csv")
+		if err != nil {
+			return nil, err
+		}
+		err = readCSVWithHeader(blocksPath, func(get func(col string) string) error {
+			loc, ok := locations[get("geoname_id")]
+			if !ok {
+				loc, ok = locations[get("registered_country_geoname_id")]
+			}
+			if !ok {
+				return nil
+			}
+			from, to, err := parseCIDRRange(get("network"))
+			if err != nil {
+				return err
+			}
+			row := oneRow{ipFrom: from, ipTo: to, ctry: loc.ctry, country: loc.country}
+			if fam == familyIPv4 {
+				r.ipv4 = append(r.ipv4, row)
+			} else {
+				r.ipv6 = append(r.ipv6, row)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	r.sort()
+	return
+}
+
+// Reads a CSV file with a header line, passing each record to the given function.  The function gets the value
+// of a column in the record by its header name, with missing columns giving an empty string
+func readCSVWithHeader(path string, fn func(get func(col string) string) error) (err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	c := csv.NewReader(bufio.NewReader(f))
+	c.FieldsPerRecord = -1
+	header, err := c.Read()
+	if err != nil {
+		return fmt.Errorf("couldn't read the header of '%s': %v", path, err)
+	}
+	cols := make(map[string]int)
+	for i, h := range header {
+		cols[h] = i
+	}
+	for line := 2; ; line++ {
+		var rec []string
+		rec, err = c.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return
+		}
+		get := func(col string) string {
+			if i, ok := cols[col]; ok && i < len(rec) {
+				return rec[i]
+			}
+			return ""
+		}
+		if err = fn(get); err != nil {
+			return fmt.Errorf("line %d of '%s': %v", line, path, err)
+		}
+	}
+}
+
+// Loads a delegated-extended statistics file, as published by each of the Regional Internet Registries.  Only
+// the IPv4 and IPv6 records which have been allocated or assigned are used.  The files don't include country
+// names, so only the country codes are imported
+func loadRIRDelegated(path string) (r *rangeList, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	r = &rangeList{format: formatRIR}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		// Skip the comments, the version line, and the summary lines
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Split(text, "|")
+		if len(fields) < 7 || fields[1] == "*" {
+			continue
+		}
+		registry, cc, recType, start, value, date, status := fields[0], fields[1], fields[2], fields[3], fields[4],
+			fields[5], fields[6]
+		if (recType != "ipv4" && recType != "ipv6") || (status != "allocated" && status != "assigned") {
+			continue
+		}
+
+		row := oneRow{registry: registry, ctry: strings.ToUpper(cc)}
+		if date != "" && date != "00000000" {
+			var t time.Time
+			if t, err = time.Parse("20060102", date); err != nil {
+				return nil, fmt.Errorf("line %d of '%s' has an invalid date '%s'", line, path, date)
+			}
+			row.assigned = int(t.Unix())
+		}
+		if recType == "ipv4" {
+			// For IPv4 the value is the number of addresses, which isn't always a power of 2
+			var count uint64
+			row.ipFrom, count, err = parseRIRIPv4(start, value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d of '%s': %v", line, path, err)
+			}
+			row.ipTo = ipNumFromUint64(row.ipFrom.lo + count - 1)
+			r.ipv4 = append(r.ipv4, row)
+		} else {
+			// For IPv6 the value is the prefix length
+			if row.ipFrom, row.ipTo, err = parseCIDRRange(start + "/" + value); err != nil {
+				return nil, fmt.Errorf("line %d of '%s': %v", line, path, err)
+			}
+			r.ipv6 = append(r.ipv6, row)
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return
+	}
+	r.sort()
+	return
+}
+
+// Parses the start address and address count of an IPv4 record from a delegated-extended statistics file
+func parseRIRIPv4(start, value string) (from ipNum, count uint64, err error) {
+	ip := net.ParseIP(start).To4()
+	if ip == nil {
+		err = fmt.Errorf("'%s' isn't a valid IPv4 address", start)
+		return
+	}
+	count, err = strconv.ParseUint(value, 10, 64)
+	if err != nil || count == 0 || ipNumFromIP(ip).lo+count-1 > maxIPv4.lo {
+		err = fmt.Errorf("'%s' isn't a valid number of addresses from %s", value, start)
+		return
+	}
+	return ipNumFromIP(ip), count, nil
+}
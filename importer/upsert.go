@@ -0,0 +1,85 @@
+package importer
+
+import (
+	"fmt"
+
+	"github.com/jackc/pgx"
+)
+
+// Returns the name of the temporary table an address family's data is copied into, before being merged into
+// the live table
+func upsertTable(fam ipFamily) string {
+	return fam.pgTable + "_upsert"
+}
+
+// Prepares an address family's table for merging the new data into, instead of dropping and recreating it.  The
+// live table is only created if it doesn't exist yet, with created saying so, as its indexes will be needed too.
+// The new data is copied into a temporary table first
+func createUpsertTables(tx *pgx.Tx, fam ipFamily) (created bool, err error) {
+	var exists bool
+	err = tx.QueryRow(`SELECT to_regclass($1) IS NOT NULL`, fam.pgTable).Scan(&exists)
+	if err != nil {
+		return
+	}
+	if !exists {
+		created = true
+		err = createLocalTable(tx, fam, fam.pgTable)
+	} else {
+		err = commentTable(tx, fam.pgTable, importComment())
+	}
+	if err != nil {
+		return
+	}
+	dbQuery := fmt.Sprintf(`CREATE TEMPORARY TABLE %s (LIKE %s) ON COMMIT DROP`, upsertTable(fam), fam.pgTable)
+	_, err = tx.Exec(dbQuery)
+	return
+}
+
+// Merges the new data for an address family into its live table.  Changed ranges are updated in place, and
+// ranges no longer in the source are removed, all inside the import transaction.  So readers see either the old
+// data or the new, and the table never goes missing
+func mergeUpsert(tx *pgx.Tx, fam ipFamily) (err error) {
+	logger.Info("Merging the new data", "family", fam.name, "table", fam.pgTable)
+
+	// Rows which were inserted rather than updated have no xmax, as no earlier version of them was replaced
+	var inserted, updated, deleted int64
+	dbQuery := fmt.Sprintf(`
+		WITH merged AS (
+			INSERT INTO %[1]s AS t (ipfrom, ipto, registry, assigned, ctry, cntry, country)
+			SELECT ipfrom, ipto, registry, assigned, ctry, cntry, country
+			FROM %[2]s
+			ON CONFLICT (ipfrom) DO UPDATE
+			SET ipto = EXCLUDED.ipto, registry = EXCLUDED.registry, assigned = EXCLUDED.assigned,
+				ctry = EXCLUDED.ctry, cntry = EXCLUDED.cntry, country = EXCLUDED.country
+			WHERE (t.ipto, t.registry, t.assigned, t.ctry, t.cntry, t.country)
+				IS DISTINCT FROM (EXCLUDED.ipto, EXCLUDED.registry, EXCLUDED.assigned, EXCLUDED.ctry,
+					EXCLUDED.cntry, EXCLUDED.country)
+			RETURNING (xmax = 0) AS inserted
+		)
+		SELECT count(*) FILTER (WHERE inserted), count(*) FILTER (WHERE NOT inserted)
+		FROM merged`, fam.pgTable, upsertTable(fam))
+	err = tx.QueryRowEx(runCtx, dbQuery, nil).Scan(&inserted, &updated)
+	if err != nil {
+		return
+	}
+
+	// Remove the ranges which aren't in the source any more
+	dbQuery = fmt.Sprintf(`
+		DELETE FROM %[1]s AS t
+		WHERE NOT EXISTS (
+			SELECT 1
+			FROM %[2]s AS n
+			WHERE n.ipfrom = t.ipfrom
+		)`, fam.pgTable, upsertTable(fam))
+	tag, err := tx.ExecEx(runCtx, dbQuery, nil)
+	if err != nil {
+		return
+	}
+	deleted = tag.RowsAffected()
+	logger.Info("Merged the new data", "family", fam.name, "inserted", inserted, "updated", updated,
+		"deleted", deleted)
+
+	// The temporary table would be dropped at commit anyway, but there's no need to keep it until then
+	_, err = tx.Exec(fmt.Sprintf(`DROP TABLE %s`, upsertTable(fam)))
+	return
+}
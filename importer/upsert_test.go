@@ -0,0 +1,106 @@
+package importer
+
+import (
+	"testing"
+)
+
+// Merges IPv6 data into a table on a real PostgreSQL server, when one is given in TEST_PG_DSN, checking the
+// values beyond 64 bits survive the COPY, the merge, and the range lookups.  Everything happens in a transaction
+// which is rolled back
+func TestUpsertIPv6Integration(t *testing.T) {
+	conn := testPGConn(t)
+	defer conn.Close()
+
+	defer setTableName(familyIPv4.pgTable)
+	setTableName("upsert_test")
+	defer func(u bool, mode string) { upsert, conf.Pg.SchemaMode = u, mode }(upsert, conf.Pg.SchemaMode)
+	upsert, conf.Pg.SchemaMode = true, ""
+
+	tx, err := conn.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	// Returns the row for a network
+	network := func(cidr, ctry string) oneRow {
+		from, to, err := parseCIDRRange(cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return oneRow{ipFrom: from, ipTo: to, registry: "test", ctry: ctry, cntry: ctry + "X", country: ctry}
+	}
+
+	// Merges the rows into the table, then returns its contents
+	merge := func(rows ...oneRow) (got []string) {
+		if _, err := createUpsertTables(tx, familyIPv6); err != nil {
+			t.Fatal(err)
+		}
+		ch := make(chan oneRow, len(rows))
+		for _, row := range rows {
+			ch <- row
+		}
+		close(ch)
+		w := &pgWriter{tx: tx}
+		if _, err := w.BulkInsert(familyIPv6, ch); err != nil {
+			t.Fatalf("BulkInsert() failed: %v", err)
+		}
+		if err := mergeUpsert(tx, familyIPv6); err != nil {
+			t.Fatalf("mergeUpsert() failed: %v", err)
+		}
+		rs, err := tx.Query(`SELECT ipfrom::text || '-' || ipto::text || ' ' || ctry FROM upsert_test_v6
+			ORDER BY ipfrom`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rs.Close()
+		for rs.Next() {
+			var s string
+			if err = rs.Scan(&s); err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, s)
+		}
+		if err = rs.Err(); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	check := func(got []string, want ...string) {
+		t.Helper()
+		if len(got) != len(want) {
+			t.Fatalf("table holds %q, want %q", got, want)
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("row %d is %q, want %q", i, got[i], want[i])
+			}
+		}
+	}
+
+	// The last network ends at the largest IPv6 address
+	jp := "42540528726795050063891204319802818560-42540569291614257367232052214305390591"
+	us := "42541956101370907050197289607612071936-42541956180599069564461627201156022271"
+	top := "340282346638528859811704183484516925440-340282366920938463463374607431768211455"
+	check(merge(network("2001:200::/23", "JP"), network("2001:4860::/32", "US"), network("ffff:ff00::/24", "ZZ")),
+		jp+" JP", us+" US", top+" ZZ")
+
+	// Change one, drop one, and add one
+	au := "42543733347512427027818187985506009088-42543733426740589542082525579049959423"
+	check(merge(network("2001:200::/23", "CN"), network("2001:a000::/32", "AU"), network("ffff:ff00::/24", "ZZ")),
+		jp+" CN", au+" AU", top+" ZZ")
+
+	// Lookups compare the numeric ranges correctly
+	for addr, want := range map[string]string{"2001:a000::1": "AU", "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff": "ZZ"} {
+		_, n, err := parseCIDRRange(addr + "/128")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ctry string
+		err = tx.QueryRow(`SELECT ctry FROM upsert_test_v6 WHERE `+lookupCondition(familyIPv6),
+			familyIPv6.keyArg(n)).Scan(&ctry)
+		if err != nil || ctry != want {
+			t.Errorf("lookup of %s = %q, %v, want %q", addr, ctry, err, want)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package importer
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+
+	"github.com/jackc/pgx"
+)
+
+// Looks up random IPv4 addresses in both the Geo-IP source and the imported PG data, checking they resolve to
+// the same country.  The expected country comes from a search of the source ranges, so this checks the range
+// containment logic works for arbitrary addresses and not just the range boundaries.  The PG data is read from the
+// given table, so it can be checked before being swapped in
+func shuffleCheck(tx *pgx.Tx, n int, table string) (mismatches int, err error) {
+	// Load the source ranges, so the containing range for each address can be found
+	var ranges []oneRow
+	err = readSource(familyIPv4, func(row oneRow) error {
+		if fixCountryCase {
+			normaliseCountryCase(&row)
+		}
+		ranges = append(ranges, row)
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	for i := 0; i < n; i++ {
+		ip := ipNumFromUint64(uint64(rand.Uint32()))
+
+		// Find the last source range starting at or before the address, then check it really contains it
+		var expected string
+		j := sort.Search(len(ranges), func(k int) bool { return ranges[k].ipFrom.cmp(ip) > 0 }) - 1
+		if j >= 0 && ip.cmp(ranges[j].ipTo) <= 0 {
+			expected = ranges[j].ctry
+		}
+
+		// Look up the address in PG the same way an application would
+		var got string
+		err = tx.QueryRow(lookupQuery(familyIPv4, table), int64(ip.lo)).Scan(&got)
+		if err != nil && err != pgx.ErrNoRows {
+			return
+		}
+		err = nil
+		if got != expected {
+			mismatches++
+			warn("Shuffle check mismatch for %s.  Source: '%s', PostgreSQL: '%s'\n", intToIPv4(int(ip.lo)), expected, got)
+		}
+	}
+	return
+}
+
+// How far the estimated row count can be from the real one, as a fraction, for -fast-verify to accept it
+const estimateTolerance = 0.05
+
+// Returns the planner's estimate of the number of rows in a table, from pg_class.reltuples.  The table is
+// analyzed first, so the estimate reflects the data just loaded
+func estimateRowCount(q queryer, table string) (count int, err error) {
+	_, err = q.Exec(fmt.Sprintf(`ANALYZE %s`, table))
+	if err != nil {
+		return
+	}
+	var est float64
+	err = q.QueryRow(`SELECT reltuples FROM pg_class WHERE oid = $1::text::regclass`, table).Scan(&est)
+	return int(est), err
+}
+
+// Returns true if the estimated count is within estimateTolerance of the expected one
+func withinTolerance(estimate, expected int) bool {
+	diff := float64(estimate - expected)
+	if diff < 0 {
+		diff = -diff
+	}
+	return diff <= float64(expected)*estimateTolerance
+}
+
+// Something which can run queries, such as a transaction or the connection pool
+type queryer interface {
+	Exec(sql string, arguments ...interface{}) (pgx.CommandTag, error)
+	Query(sql string, args ...interface{}) (*pgx.Rows, error)
+	QueryRow(sql string, args ...interface{}) *pgx.Row
+}
+
+// Checks the canonical lookup query uses an index rather than a sequential scan of the given country lookup
+// table, for both the lowest and the highest address in it.  The primary key only makes lookups near the start of
+// the address space selective, so the highest address checks the ipto index is usable too.  (An address in the
+// middle of the table matches about half the rows on either column, so the planner rightly scans the table for
+// it whatever the indexes are, and it can't be used to check them)
+func assertIndexUsed(q queryer, fam ipFamily, table string) (err error) {
+	// Make sure the planner has up to date statistics for the table, as it may have only just been loaded
+	_, err = q.Exec(`ANALYZE ` + table)
+	if err != nil {
+		return
+	}
+	var lowest, highest string
+	dbQuery := fmt.Sprintf(`SELECT coalesce(min(ipfrom)::text, ''), coalesce(max(ipto)::text, '') FROM %s WHERE %s`,
+		table, familyCondition(fam))
+	err = q.QueryRow(dbQuery).Scan(&lowest, &highest)
+	if err == nil && lowest == "" {
+		err = fmt.Errorf("the table is empty")
+	}
+	if err != nil {
+		return fmt.Errorf("couldn't find an address to check the %s query plan with: %v", fam.name, err)
+	}
+
+	for _, addr := range []string{lowest, highest} {
+		var n ipNum
+		n, err = parseIPNum(addr)
+		if err != nil {
+			return
+		}
+		var plan []string
+		plan, err = explainLookup(q, fam, table, n)
+		if err != nil {
+			return
+		}
+		if planSeqScans(plan, table) {
+			return fmt.Errorf("The %s lookup query uses a sequential scan of %s instead of an index, when "+
+				"looking up %s.  Query plan:\n%s", fam.name, table, formatIPNum(fam, n), strings.Join(plan, "\n"))
+		}
+		logger.Debug("Lookup query plan uses an index", "family", fam.name, "address", formatIPNum(fam, n),
+			"plan", strings.Join(plan, "\n"))
+	}
+	return nil
+}
+
+// Returns the lines of the query plan for looking up an address in the table
+func explainLookup(q queryer, fam ipFamily, table string, n ipNum) (plan []string, err error) {
+	rows, err := q.Query(`EXPLAIN `+lookupQuery(fam, table), fam.keyArg(n))
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var line string
+		if err = rows.Scan(&line); err != nil {
+			return
+		}
+		plan = append(plan, line)
+	}
+	err = rows.Err()
+	return
+}
+
+// Returns true if the lines of an EXPLAIN query plan include a sequential scan of the table.  Other tables in the
+// plan, and tables whose names merely start with the same text, don't count
+func planSeqScans(plan []string, table string) bool {
+	for _, line := range plan {
+		i := strings.Index(line, "Seq Scan on ")
+		if i < 0 {
+			continue
+		}
+		fields := strings.Fields(line[i+len("Seq Scan on "):])
+		if len(fields) > 0 && fields[0] == table {
+			return true
+		}
+	}
+	return false
+}
+
+// Checks the lookup query against the live tables uses an index.  The IPv6 table (or with a combined table, its
+// IPv6 rows) is only checked if it exists, as older Geo-IP sources don't have IPv6 data
+func assertIndexUsedCmd() (err error) {
+	err = connectPG()
+	if err != nil {
+		return
+	}
+	defer pg.Close()
+	for _, fam := range families {
+		if fam == familyIPv6 {
+			var exists bool
+			dbQuery := fmt.Sprintf(`SELECT to_regclass('%s') IS NOT NULL`, fam.pgTable)
+			if combinedTable {
+				dbQuery = fmt.Sprintf(`SELECT EXISTS (SELECT 1 FROM %s WHERE %s)`, fam.pgTable, familyCondition(fam))
+			}
+			err = pg.QueryRow(dbQuery).Scan(&exists)
+			if err != nil {
+				return
+			}
+			if !exists {
+				continue
+			}
+		}
+		err = assertIndexUsed(pg, fam, fam.pgTable)
+		if err != nil {
+			return
+		}
+	}
+	fmt.Println("The lookup query uses an index")
+	return
+}
+
+// Formats an integer IPv4 address in dotted quad notation
+func intToIPv4(ip int) string {
+	return fmt.Sprintf("%d.%d.%d.%d", byte(ip>>24), byte(ip>>16), byte(ip>>8), byte(ip))
+}
@@ -0,0 +1,52 @@
+package importer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Sends the import summary to the webhook URL.  Failures are only logged, as they shouldn't fail the import
+func postWebhook(url string, summary Result) {
+	var payload interface{} = summary
+	if webhookSlack {
+		payload = struct {
+			Text string `json:"text"`
+		}{slackMessage(summary)}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		logger.Warn("Couldn't encode the webhook payload", "err", err)
+		return
+	}
+
+	// The error and warnings are already masked, but make sure nothing else lets a secret through
+	body = []byte(redactPassword(string(body)))
+
+	client := http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		logger.Warn("Posting to the webhook failed", "err", err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		logger.Warn("Webhook returned an unexpected status", "status", resp.Status)
+	}
+}
+
+// Formats the import summary as a short human readable message
+func slackMessage(summary Result) string {
+	if summary.Partial {
+		return fmt.Sprintf("Geo-IP import from %s was only partly applied after %.1f seconds: %s", summary.Source,
+			summary.Duration, summary.Error)
+	}
+	if !summary.Success {
+		return fmt.Sprintf("Geo-IP import from %s failed after %.1f seconds: %s", summary.Source,
+			summary.Duration, summary.Error)
+	}
+	return fmt.Sprintf("Geo-IP import from %s succeeded in %.1f seconds.  IPv4 rows: %d, IPv6 rows: %d, "+
+		"warnings: %d", summary.Source, summary.Duration, summary.IPv4Rows, summary.IPv6Rows, summary.WarningCount)
+}
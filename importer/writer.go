@@ -0,0 +1,266 @@
+package importer
+
+import (
+	"fmt"
+
+	"github.com/jackc/pgx"
+)
+
+// Supported output target drivers
+const (
+	driverPostgreSQL = "postgresql"
+	driverMySQL      = "mysql"
+	driverRedshift   = "redshift"
+	driverSQLite     = "sqlite"
+)
+
+// A database the country lookup data is written to.  For each address family, CreateSchema creates an empty
+// table, BulkInsert writes the rows sent to it until the channel is closed, BuildIndexes creates the configured
+// indexes, and Verify checks the table has the same number of rows as the source, returning the count.  Nothing
+// written is visible to anyone else until Commit is called, and Close discards it if Commit wasn't
+type writer interface {
+	CreateSchema(fam ipFamily) error
+	BulkInsert(fam ipFamily, rows <-chan oneRow) (int64, error)
+	BuildIndexes(fam ipFamily) error
+	Verify(fam ipFamily) (int, error)
+	Commit() error
+	Close()
+}
+
+// Returns the output target driver, defaulting to PostgreSQL
+func targetDriver() string {
+	if conf.Target.Driver == "" {
+		return driverPostgreSQL
+	}
+	return conf.Target.Driver
+}
+
+// Checks the output target settings.  Most of the options are specific to PostgreSQL, so they're rejected for the
+// other targets rather than silently ignored
+func validateTarget() error {
+	driver := targetDriver()
+	switch driver {
+	case driverPostgreSQL:
+		return nil
+	case driverMySQL:
+		if conf.Target.Database == "" {
+			return fmt.Errorf("The mysql target needs a database")
+		}
+	case driverRedshift:
+		switch {
+		case conf.Target.Server == "" || conf.Target.Database == "":
+			return fmt.Errorf("The redshift target needs a server and database")
+		case conf.Target.S3Bucket == "" || conf.Target.S3Region == "":
+			return fmt.Errorf("The redshift target needs an s3_bucket and s3_region to load the data through")
+		case s3AccessKey(conf) == "" || s3SecretKey(conf) == "":
+			return fmt.Errorf("The redshift target needs S3 access keys to upload the data with")
+		}
+	case driverSQLite:
+		if conf.Target.Path == "" {
+			return fmt.Errorf("The sqlite target needs a path")
+		}
+	default:
+		return fmt.Errorf("Unknown target driver '%s'", driver)
+	}
+	switch {
+	case upsert:
+		return fmt.Errorf("-upsert is only supported for the postgresql target")
+	case conf.FDW.Server != "":
+		return fmt.Errorf("Foreign tables are only supported for the postgresql target")
+	case len(conf.Tables) > 0:
+		return fmt.Errorf("Extra output tables are only supported for the postgresql target")
+	case conf.Pg.SchemaMode != "":
+		return fmt.Errorf("schema_mode is only supported for the postgresql target")
+	case assertIndex || shuffleCheckCount > 0 || checksumCheck || sampleCheckCount > 0:
+		return fmt.Errorf("The post import checks are only supported for the postgresql target")
+	}
+	return nil
+}
+
+// Opens the writer for the configured output target, other than PostgreSQL
+func openWriter() (w writer, err error) {
+	switch targetDriver() {
+	case driverMySQL:
+		return openMySQLWriter()
+	case driverRedshift:
+		return openRedshiftWriter()
+	case driverSQLite:
+		return openSQLiteWriter()
+	}
+	return nil, fmt.Errorf("No writer for the %s target", targetDriver())
+}
+
+// Imports the Geo-IP source data into a target other than PostgreSQL.  This is the same sequence as the
+// PostgreSQL import, minus the PostgreSQL specific options
+func importToTarget(summary *Result) (err error) {
+	defer summary.endPhase()
+
+	summary.startPhase("Open source")
+	closeSource, err := openSource()
+	if err != nil {
+		return
+	}
+	defer closeSource()
+
+	summary.startPhase("Create tables")
+	w, err := openWriter()
+	if err != nil {
+		return
+	}
+	defer w.Close()
+
+	for _, fam := range families {
+		var has bool
+		has, err = sourceHasFamily(fam)
+		if err != nil {
+			return
+		}
+		if !has {
+			logger.Info("The Geo-IP source has no data for the address family, so skipping it", "family", fam.name)
+			continue
+		}
+		err = w.CreateSchema(fam)
+		if err != nil {
+			return
+		}
+
+		summary.startPhase("Import " + fam.name)
+		err = importFamily(w, fam, nil)
+		if err != nil {
+			return
+		}
+
+		summary.startPhase("Index " + fam.name)
+		logger.Info("Creating the indexes", "family", fam.name)
+		err = w.BuildIndexes(fam)
+		if err != nil {
+			return
+		}
+
+		summary.startPhase("Verify " + fam.name)
+		var count int
+		count, err = w.Verify(fam)
+		if err != nil {
+			return
+		}
+		if fam == familyIPv4 {
+			summary.IPv4Rows = count
+		} else {
+			summary.IPv6Rows = count
+		}
+	}
+
+	// If requested, treat any warnings raised during the import as fatal
+	if warningCount > 0 {
+		logger.Warn("Warnings were raised during the import", "warnings", warningCount)
+		if rollbackOnWarning {
+			return fmt.Errorf("Import rolled back due to %d warning(s)", warningCount)
+		}
+	}
+
+	summary.startPhase("Commit")
+	return w.Commit()
+}
+
+// Checks the number of rows written to a target table matches the Geo-IP source, returning the count
+func checkRowCount(fam ipFamily, written int) (count int, err error) {
+	count, err = countSource(fam)
+	if err != nil {
+		return
+	}
+	if written != count {
+		err = fmt.Errorf("Mismatching %s row counts after import.  Source: %d, %s: %d", fam.name, count,
+			targetDriver(), written)
+	}
+	return
+}
+
+// Writes the country lookup data to PostgreSQL, in a single transaction.  The PostgreSQL only parts of the import,
+// such as upserts and the swap of the staging tables, are handled by runImport around it
+type pgWriter struct {
+	tx        *pgx.Tx
+	committed bool
+
+	// The staging tables which have been created, and had their indexes built.  The address families share one
+	// with a combined table, so it's only set up for the first
+	created, indexed map[string]bool
+}
+
+// Creates the staging table for the address family
+func (w *pgWriter) CreateSchema(fam ipFamily) error {
+	table := stagingTable(fam)
+	if w.created[table] {
+		return nil
+	}
+	if w.created == nil {
+		w.created = make(map[string]bool)
+	}
+	w.created[table] = true
+	return createLocalTable(w.tx, fam, table)
+}
+
+// Streams the rows into the address family's new data table (or its upsert table) using the COPY protocol
+func (w *pgWriter) BulkInsert(fam ipFamily, rows <-chan oneRow) (int64, error) {
+	table := newDataTable(fam)
+	if upsert {
+		table = upsertTable(fam)
+	}
+	src := &copySource{rows: rows, values: func(row oneRow) []interface{} {
+		return []interface{}{fam.copyArg(row.ipFrom), fam.copyArg(row.ipTo), row.registry, row.assigned, row.ctry,
+			row.cntry, row.country}
+	}}
+	columns := copyColumns
+	if combinedTable {
+		columns = append([]string{"family"}, copyColumns...)
+		values := src.values
+		src.values = func(row oneRow) []interface{} {
+			return append([]interface{}{int16(fam.version)}, values(row)...)
+		}
+	}
+	count, err := w.tx.CopyFrom(pgx.Identifier{table}, columns, src)
+	return int64(count), err
+}
+
+// Creates the configured indexes, and the range column's index if there is one
+func (w *pgWriter) BuildIndexes(fam ipFamily) (err error) {
+	table := newDataTable(fam)
+	if w.indexed[table] {
+		return nil
+	}
+	if w.indexed == nil {
+		w.indexed = make(map[string]bool)
+	}
+	w.indexed[table] = true
+	_, statements := lookupIndexSQL(table)
+	for _, dbQuery := range statements {
+		_, err = w.tx.ExecEx(runCtx, dbQuery, nil)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (w *pgWriter) Verify(fam ipFamily) (int, error) {
+	return verifyRowCount(w.tx, fam, newDataTable(fam))
+}
+
+func (w *pgWriter) Commit() (err error) {
+	err = w.tx.Commit()
+	if err != nil {
+		return
+	}
+	w.committed = true
+	return
+}
+
+// Rolls back the transaction, if it wasn't committed
+func (w *pgWriter) Close() {
+	if w.committed {
+		return
+	}
+	err := w.tx.Rollback()
+	if err != nil {
+		logger.Error("Rolling back the import failed", "err", err)
+	}
+}
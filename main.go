@@ -1,236 +1,281 @@
 package main
 
 import (
-	"crypto/tls"
+	"context"
+	"flag"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
 	"path/filepath"
-	"time"
+	"runtime"
+	"runtime/pprof"
+	"strconv"
+	"strings"
+	"syscall"
 
-	"github.com/BurntSushi/toml"
-	sqlite "github.com/gwenn/gosqlite"
-	"github.com/jackc/pgx"
+	"github.com/justinclift/ip_country_code_lookup_importer/importer"
 	"github.com/mitchellh/go-homedir"
 )
 
-// Configuration file
-type TomlConfig struct {
-	Geo GeoInfo
-	Pg  PGInfo
-}
-type GeoInfo struct {
-	Path string // Path to the Geo-IP.sqlite file
-}
-type PGInfo struct {
-	Database       string
-	NumConnections int `toml:"num_connections"`
-	Port           int
-	Password       string
-	Server         string
-	SSL            bool
-	Username       string
-}
-
-type oneRow struct {
-	ipFrom   int
-	ipTo     int
-	registry string
-	assigned int
-	ctry     string
-	cntry    string
-	country  string
-}
-
 var (
-	// Application config
-	Conf TomlConfig
-
-	// Display debugging messages?
-	debug = true
+	// The importer, once the settings have been read.  It's used to mask the secrets in fatal errors
+	imp *importer.Importer
 
-	// PostgreSQL Connection pool
-	pg *pgx.ConnPool
-
-	// SQLite pieces
-	sdb *sqlite.Conn
+	// The CPU profile being written.  Nil when there isn't one
+	cpuProfileFile *os.File
 )
 
 func main() {
-	// Override config file location via environment variables
+	// Parse the command line flags.  Debugging messages are on unless turned off with -debug=false, or the DEBUG
+	// environment variable
+	debugDefault := true
+	if v, err := strconv.ParseBool(os.Getenv("DEBUG")); err == nil {
+		debugDefault = v
+	}
+	defaults := importer.DefaultOptions()
+	var opts importer.Options
+	debug := flag.Bool("debug", debugDefault, "Log the debugging messages, by defaulting the log level to debug")
+	logLevel := flag.String("log-level", os.Getenv("LOG_LEVEL"), "Log level.  Either \"debug\", \"info\", \"warn\", or \"error\" (overrides -debug)")
+	logFormat := flag.String("log-format", "", "Log format.  Either \"text\" (the default) or \"json\"")
+	flag.IntVar(&opts.ProgressEvery, "progress-every", defaults.ProgressEvery, "Report progress every this many rows imported (0 turns it off)")
+	profile := flag.String("profile", os.Getenv("PROFILE"), "Name of the config file profile to use")
+	configPath := flag.String("config", os.Getenv("CONFIG_FILE"), "Config file to use (defaults to ~/.db4s/status_updater.toml)")
+
+	// These override the config file.  The PostgreSQL password is only taken from the PG_PASSWORD environment
+	// variable, so it doesn't show up in process listings
+	geoPath := flag.String("geo-path", os.Getenv("GEO_PATH"), "Path to the Geo-IP source file")
+	pgServer := flag.String("pg-server", os.Getenv("PG_SERVER"), "PostgreSQL server")
+	pgPortDefault := 0
+	if v := os.Getenv("PG_PORT"); v != "" {
+		var err error
+		if pgPortDefault, err = strconv.Atoi(v); err != nil {
+			log.Fatalf("Invalid PG_PORT '%s'\n", v)
+		}
+	}
+	pgPort := flag.Int("pg-port", pgPortDefault, "PostgreSQL port")
+	pgDatabase := flag.String("pg-database", os.Getenv("PG_DATABASE"), "PostgreSQL database")
+	pgUser := flag.String("pg-user", os.Getenv("PG_USER"), "PostgreSQL user name")
+	flag.StringVar(&opts.TableName, "table", os.Getenv("TABLE_NAME"), "Name of the IPv4 country lookup table, with _v6 appended for the IPv6 one (default \"country_code_lookups\")")
+	dryRun := flag.Bool("dry-run", false, "Read the source and show the DDL and row counts for the import, without touching PostgreSQL")
+	flag.BoolVar(&opts.RollbackOnWarning, "rollback-on-warning", false, "Roll back the import if any warnings are raised")
+	flag.StringVar(&opts.TableComment, "table-comment", "", "Comment to place on the country lookup table (defaults to the source and import time)")
+	cpuProfile := flag.String("cpuprofile", "", "Write a CPU profile of the import to this file")
+	memProfile := flag.String("memprofile", "", "Write a memory profile to this file at the end of the import")
+	flag.BoolVar(&opts.FastVerify, "fast-verify", false, "Verify the row count using the planner's (approximate) estimate instead of count(*)")
+	daemon := flag.Bool("daemon", false, "Keep running, and refresh the data on the schedule given in the config file")
+	flag.BoolVar(&opts.Force, "force", false, "When the Geo-IP source has a URL, download and import it even if it hasn't changed")
+	flag.BoolVar(&opts.Upsert, "upsert", false, "Merge the new data into the existing tables, instead of dropping and recreating them")
+	flag.BoolVar(&opts.CombinedTable, "combined-table", false, "Keep the IPv4 and IPv6 data in one table, with a family column, instead of a separate _v6 table")
+	mode := flag.String("mode", "replace", "How to load the new data.  Either \"replace\" the tables, or \"diff\" to only apply the changes (same as -upsert)")
+	flag.BoolVar(&opts.FixCountryCase, "fix-country-case", false, "Upper case the 2 and 3 letter country codes")
+	flag.StringVar(&opts.CountryNameCase, "country-name-case", defaults.CountryNameCase, "With -fix-country-case, how to treat country names. Either \"keep\" or \"title\"")
+	flag.IntVar(&opts.MaxRangeSize, "max-range-size", 0, "Warn about ranges covering more than this many addresses (defaults to per registry limits)")
+	indexList := flag.String("indexes", "ipto", "Comma separated list of indexes to create. Join columns with + for multi-column indexes")
+	noIPToIndex := flag.Bool("no-ipto-index", false, "Don't create the index on the ipto column")
+	flag.DurationVar(&opts.HeartbeatInterval, "connection-check-interval", 0, "Log a heartbeat and check the PG connection this often during the import (eg 5m)")
+	flag.IntVar(&opts.MinFreeDiskMB, "min-free-disk", defaults.MinFreeDiskMB, "MB of disk space which needs to remain free after extracting the Geo-IP file from an archive")
+	flag.BoolVar(&opts.AssertIndexUsed, "assert-index-used", false, "After importing, fail if the lookup query doesn't use an index")
+	flag.BoolVar(&opts.ChecksumVerify, "checksum-verify", false, "After importing, fail if per column checksums of the data don't match the source")
+	flag.IntVar(&opts.SampleCheck, "sample-check", 0, "After importing, fail if any of this many random rows don't match the source field by field")
+	flag.IntVar(&opts.ShuffleCheck, "shuffle-check", 0, "After importing, check this many random addresses resolve the same in the source and PG")
+	flag.StringVar(&opts.WebhookURL, "webhook", "", "POST a JSON summary of the import to this URL when it finishes")
+	flag.BoolVar(&opts.WebhookSlack, "webhook-slack", false, "Send the webhook summary as a Slack compatible message")
+	flag.Parse()
+
+	// Start CPU profiling, if requested
+	if *cpuProfile != "" {
+		f, err := os.Create(*cpuProfile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = pprof.StartCPUProfile(f)
+		if err != nil {
+			log.Fatal(err)
+		}
+		cpuProfileFile = f
+		defer stopCPUProfile()
+	}
+
 	var err error
-	configFile := os.Getenv("CONFIG_FILE")
+	opts.Indexes, err = importer.ParseIndexSpecs(*indexList, *noIPToIndex)
+	if err != nil {
+		fatal(err)
+	}
+	switch *mode {
+	case "replace":
+	case "diff":
+		opts.Upsert = true
+	default:
+		fatalf("Unknown mode '%s'.  Needs to be either \"replace\" or \"diff\"\n", *mode)
+	}
+
+	// The config file can be given with -config or the CONFIG_FILE environment variable.  The default one is
+	// optional, so everything can be given on the command line instead
+	configFile := *configPath
 	if configFile == "" {
 		userHome, err := homedir.Dir()
 		if err != nil {
-			log.Fatalf("User home directory couldn't be determined: %s", "\n")
+			fatalf("User home directory couldn't be determined: %s", "\n")
 		}
 		configFile = filepath.Join(userHome, ".db4s", "status_updater.toml")
+		if _, statErr := os.Stat(configFile); statErr != nil {
+			configFile = ""
+		}
 	}
 
-	// Read our configuration settings
-	if _, err = toml.DecodeFile(configFile, &Conf); err != nil {
-		log.Fatal(err)
+	// Read our configuration settings, with the selected profile applied on top of the top level ones
+	cfg, err := importer.LoadConfig(configFile, *profile)
+	if err != nil {
+		fatal(err)
 	}
 
-	// Open the Geo-IP database, for country lookups
-	sdb, err = sqlite.Open(Conf.Geo.Path)
-	if err != nil {
-		log.Fatal(err)
+	// The log level defaults to following -debug
+	if *logLevel != "" {
+		cfg.Log.Level = *logLevel
 	}
-	defer func() {
-		err = sdb.Close()
-		if err != nil {
-			log.Println(err)
+	if *logFormat != "" {
+		cfg.Log.Format = *logFormat
+	}
+	if cfg.Log.Level == "" {
+		cfg.Log.Level = "info"
+		if *debug {
+			cfg.Log.Level = "debug"
 		}
-	}()
-
-	// Log successful connection
-	if debug {
-		fmt.Printf("Connected to Geo-IP database: %v\n", Conf.Geo.Path)
-	}
-
-	// Setup the PostgreSQL config
-	pgConfig := new(pgx.ConnConfig)
-	pgConfig.Host = Conf.Pg.Server
-	pgConfig.Port = uint16(Conf.Pg.Port)
-	pgConfig.User = Conf.Pg.Username
-	pgConfig.Password = Conf.Pg.Password
-	pgConfig.Database = Conf.Pg.Database
-	clientTLSConfig := tls.Config{InsecureSkipVerify: true}
-	if Conf.Pg.SSL {
-		pgConfig.TLSConfig = &clientTLSConfig
-	} else {
-		pgConfig.TLSConfig = nil
-	}
-
-	// Connect to PG
-	pgPoolConfig := pgx.ConnPoolConfig{*pgConfig, Conf.Pg.NumConnections, nil, 5 * time.Second}
-	pg, err = pgx.NewConnPool(pgPoolConfig)
-	if err != nil {
-		log.Fatal(err)
 	}
-	defer pg.Close()
 
-	// Log successful connection
-	if debug {
-		fmt.Printf("Connected to PostgreSQL server: %v\n", Conf.Pg.Server)
+	// Apply the command line overrides
+	if *geoPath != "" {
+		cfg.Geo.Path = *geoPath
 	}
-
-	// Begin PostgreSQL transaction
-	tx, err := pg.Begin()
-	if err != nil {
-		log.Fatal(err)
+	if *pgServer != "" {
+		cfg.Pg.Server = *pgServer
+	}
+	if *pgPort != 0 {
+		cfg.Pg.Port = *pgPort
 	}
-	// Set up an automatic transaction roll back if the function exits without committing
-	defer func() {
-		err = tx.Rollback()
+	if *pgDatabase != "" {
+		cfg.Pg.Database = *pgDatabase
+	}
+	if *pgUser != "" {
+		cfg.Pg.Username = *pgUser
+	}
+	if v := os.Getenv("PG_PASSWORD"); v != "" {
+		cfg.Pg.Password = v
+	}
+	cfg.Options = opts
+	imp = importer.New(cfg)
+
+	// Stop cleanly on SIGINT or SIGTERM, rolling back an import which is part way through
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Just show what would be done, if requested.  A dry run only covers the import, so it's an error to combine it
+	// with a subcommand or daemon mode rather than quietly ignoring them
+	if *dryRun {
+		if flag.NArg() > 0 {
+			fatalf("-dry-run can't be used with the '%s' subcommand\n", flag.Arg(0))
+		}
+		if *daemon {
+			fatal("-dry-run can't be used with -daemon")
+		}
+		err = imp.DryRun(ctx)
 		if err != nil {
-			log.Println(err)
+			fatal(err)
 		}
-	}()
+		return
+	}
 
-	// Drop existing PG tables holding the IP country lookup data
-	fmt.Print("Dropping existing IPv4 data table from PG")
-	dbQuery := `DROP TABLE IF EXISTS country_code_lookups`
-	_, err = tx.Exec(dbQuery)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	// Create the PG tables to hold the country lookup data
-	fmt.Print("Creating new IPv4 data table in PG")
-	dbQuery = `
-		CREATE TABLE country_code_lookups (
-			ipfrom bigint constraint country_code_lookups_pk primary key,
-			ipto bigint,
-			registry text,
-			assigned bigint,
-			ctry text,
-			cntry text,
-			country text
-		)`
-	_, err = tx.Exec(dbQuery)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	// Import the IP country lookup data from SQLite to PG
-	fmt.Print("Importing IPv4 data table from SQLite to PG")
-	sQuery := `
-		SELECT IPFROM, IPTO, REGISTRY, ASSIGNED, CTRY, CNTRY, COUNTRY
-		FROM ipv4
-		ORDER BY IPFROM ASC`
-	err = sdb.Select(sQuery, func(s *sqlite.Stmt) (innerErr error) {
-		var row oneRow
-		innerErr = s.Scan(&row.ipFrom, &row.ipTo, &row.registry, &row.assigned, &row.ctry, &row.cntry, &row.country)
-		if innerErr != nil {
-			return
+	// Run a subcommand, if one was given
+	if flag.NArg() > 0 {
+		err = imp.RunCommand(ctx, flag.Args())
+		if err != nil {
+			fatal(err)
 		}
+		return
+	}
 
-		// Insert the row into PG
-		innerErr = insertIPv4PGData(tx, row)
+	// Keep refreshing the data on a schedule, if requested
+	if *daemon {
+		err = imp.Daemon(ctx)
+		if err != nil {
+			fatal(err)
+		}
 		return
-	})
-	if err != nil {
-		log.Fatal(err)
 	}
 
-	// TODO: Import the IPv6 data from SQLite to PG
+	// Run the download and import
+	result, err := imp.Run(ctx)
 
-	// Create appropriate indexes on the new PG country lookup data
-	fmt.Print("Creating indexes in PG")
-	dbQuery = `
-		CREATE INDEX country_code_lookups_ipto_index
-		ON country_code_lookups (ipto)`
-	_, err = tx.Exec(dbQuery)
+	// Write the memory profile, if requested
+	if *memProfile != "" {
+		err := writeMemProfile(*memProfile)
+		if err != nil {
+			log.Printf("Couldn't write the memory profile: %v", err)
+		}
+	}
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
+	}
+	if result.Skipped {
+		fmt.Println("The Geo-IP source hasn't changed since the last import, so there's nothing to do")
+		return
 	}
 
-	// Verify the same number of entries in both the SQLite and PG tables
-	var pgRowCount, sRowCount int
-	dbQuery = `SELECT count(*) FROM country_code_lookups`
-	err = tx.QueryRow(dbQuery).Scan(&pgRowCount)
-	if err != nil {
-		log.Fatalf("error when counting rows in the pg table: %v\n", err)
+	// Let the user know the import is complete
+	fmt.Println("Import of SQLite country lookup data is complete")
+	printSummary(result, strings.EqualFold(cfg.Log.Level, "debug"))
+}
+
+// Prints the row counts and time taken by a finished import, optionally with the breakdown of the phases
+func printSummary(r importer.Result, phases bool) {
+	fmt.Printf("Imported %d IPv4 and %d IPv6 rows in %.1f seconds\n", r.IPv4Rows, r.IPv6Rows, r.Duration)
+	if !phases {
+		return
+	}
+	for _, p := range r.Phases {
+		fmt.Printf("  %-20s %8.1fs\n", p.Name, p.Duration)
 	}
+}
 
-	sQuery = `SELECT count(*) FROM ipv4`
-	err = sdb.Select(sQuery, func(s *sqlite.Stmt) (innerErr error) {
-		innerErr = s.Scan(&sRowCount)
+// Finishes writing the CPU profile, if one is being written
+func stopCPUProfile() {
+	if cpuProfileFile == nil {
 		return
-	})
-	if err != nil {
-		log.Fatal(err)
 	}
-	if pgRowCount != sRowCount {
-		log.Fatalf("Mismatching IPv4 row counts after import.  SQLite: %d, PostgreSQL: %d\n", sRowCount, pgRowCount)
+	pprof.StopCPUProfile()
+	err := cpuProfileFile.Close()
+	if err != nil {
+		log.Printf("Couldn't close the CPU profile: %v", err)
 	}
+	cpuProfileFile = nil
+}
 
-	// TODO: Figure out why the transaction is already complete here
-	//// Commit PostgreSQL transaction
-	//err = tx.Commit()
-	//if err != nil {
-	//	log.Fatal(err)
-	//}
+// Logs the message with any secrets masked, then exits with a failure status.  Unlike log.Fatal, this finishes
+// the CPU profile first, as deferred calls don't run on exit and a failed import is often the one worth profiling
+func fatal(v ...interface{}) {
+	stopCPUProfile()
+	msg := fmt.Sprint(v...)
+	if imp != nil {
+		msg = imp.Redact(msg)
+	}
+	log.Fatal(msg)
+}
 
-	// Let the user know the import is complete
-	fmt.Println("Import of SQLite country lookup data is complete")
+// Like fatal, but with a format string
+func fatalf(format string, v ...interface{}) {
+	fatal(fmt.Sprintf(format, v...))
 }
 
-// Inserts a single data record into the PostgreSQL database
-func insertIPv4PGData(tx *pgx.Tx, row oneRow) (err error) {
-	var tag pgx.CommandTag
-	dbQuery := `
-		INSERT INTO country_code_lookups (ipfrom, ipto, registry, assigned, ctry, cntry, country)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)`
-	tag, err = tx.Exec(dbQuery, row.ipFrom, row.ipTo, row.registry, row.assigned, row.ctry, row.cntry, row.country)
+// Writes a heap profile to the given file
+func writeMemProfile(path string) (err error) {
+	f, err := os.Create(path)
 	if err != nil {
-		log.Fatal(err)
-	}
-	if numRows := tag.RowsAffected(); numRows != 1 {
-		log.Printf("Wrong number of rows affected (%d) when insert ip lookup data. ipfrom: %v, \n", numRows, row.ipFrom)
+		return
 	}
-	return
+	defer f.Close()
+
+	// Get up to date statistics
+	runtime.GC()
+	return pprof.WriteHeapProfile(f)
 }
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/justinclift/ip_country_code_lookup_importer/importer"
+)
+
+const testPassword = "s3cr3t-Pa55"
+
+// The fatal exits can't be tested in process, so this runs the test binary again to call fatal()
+func TestFatalHidesPassword(t *testing.T) {
+	if os.Getenv("TEST_FATAL_REDACTION") == "1" {
+		var cfg importer.Config
+		cfg.Pg.Password = testPassword
+		imp = importer.New(cfg)
+		fatal(errors.New("password authentication failed: " + testPassword))
+		return
+	}
+	cmd := exec.Command(os.Args[0], "-test.run=^TestFatalHidesPassword$")
+	cmd.Env = append(os.Environ(), "TEST_FATAL_REDACTION=1")
+	out, err := cmd.CombinedOutput()
+	if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.Success() {
+		t.Fatalf("fatal() didn't exit with a failure status: %v", err)
+	}
+	if strings.Contains(string(out), testPassword) {
+		t.Errorf("fatal() output includes the password: %s", out)
+	}
+	if !strings.Contains(string(out), "password authentication failed: ****") {
+		t.Errorf("fatal() output = %q, want the masked message", out)
+	}
+}